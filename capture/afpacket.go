@@ -0,0 +1,248 @@
+//go:build linux
+// +build linux
+
+package capture
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/afpacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"golang.org/x/net/bpf"
+)
+
+// The ARPHRD types of the devices that do not deliver Ethernet frames, see
+// linux/if_arp.h.
+const (
+	arphrdIEEE80211         = 801
+	arphrdIEEE80211Radiotap = 803
+)
+
+var fanoutTypes = map[string]afpacket.FanoutType{
+	"hash":          afpacket.FanoutHash,
+	"lb":            afpacket.FanoutLoadBalance,
+	"cpu":           afpacket.FanoutCPU,
+	"rollover":      afpacket.FanoutRollover,
+	"random":        afpacket.FanoutRandom,
+	"queue-mapping": afpacket.FanoutQueueMapping,
+}
+
+// The afPacket struct delivers the packets read from the memory-mapped ring
+// buffers of one or more AF_PACKET sockets on a single device.
+type afPacket struct {
+	device   string
+	linkType layers.LinkType
+	sockets  []*afpacket.TPacket
+	rewrite  func(packet gopacket.Packet) ([]byte, error)
+	packets  chan Packet
+	done     chan struct{}
+	wg       sync.WaitGroup
+	once     sync.Once
+}
+
+// NewAFPacket returns a passive Source capturing on device with TPACKET_V3
+// ring buffers, configured by c. Packets longer than snaplen may be truncated,
+// and only those matching the BPF filter, if not empty, are captured. The
+// sockets of the device join a fanout group, so that the kernel spreads the
+// packets over them, and over the sockets of other processes in the same
+// group; index is the position of the device on the command line, which
+// decides its group. Accepted packets are handed to rewrite, if it is not nil,
+// and the data it returns is written back onto the device.
+func NewAFPacket(device string, index int, snaplen int, filter string, c *AFPacketConfig,
+	rewrite func(packet gopacket.Packet) ([]byte, error)) (Source, error) {
+	s := &afPacket{
+		device:   device,
+		linkType: deviceLinkType(device),
+		rewrite:  rewrite,
+		packets:  make(chan Packet),
+		done:     make(chan struct{}),
+	}
+
+	var instructions []bpf.RawInstruction
+	if filter != "" {
+		compiled, err := pcap.CompileBPFFilter(s.linkType, snaplen, filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range compiled {
+			instructions = append(instructions, bpf.RawInstruction{Op: i.Code, Jt: i.Jt, Jf: i.Jf, K: i.K})
+		}
+	}
+
+	// A group of zero is only shared by the sockets of this process.
+	group := c.FanoutGroup
+	if group == 0 {
+		group = uint16(os.Getpid())
+	}
+	group += uint16(index)
+	fanout := c.Sockets > 1 || c.FanoutGroup != 0
+
+	for i := 0; i < c.Sockets; i++ {
+		socket, err := afpacket.NewTPacket(
+			afpacket.OptInterface(device),
+			afpacket.OptTPacketVersion(afpacket.TPacketVersion3),
+			afpacket.OptFrameSize(frameSize(snaplen, os.Getpagesize(), c.BlockSize)),
+			afpacket.OptBlockSize(c.BlockSize),
+			afpacket.OptNumBlocks(c.NumBlocks),
+			afpacket.OptPollTimeout(c.PollTimeout.Duration),
+		)
+		if err != nil {
+			s.closeSockets()
+			return nil, fmt.Errorf("Can't open AF_PACKET socket on %s: %v", device, err)
+		}
+		s.sockets = append(s.sockets, socket)
+
+		if instructions != nil {
+			if err = socket.SetBPF(instructions); err != nil {
+				s.closeSockets()
+				return nil, err
+			}
+		}
+		if fanout {
+			if err = socket.SetFanout(fanoutTypes[c.FanoutType], group); err != nil {
+				s.closeSockets()
+				return nil, fmt.Errorf("Can't join fanout group %d on %s: %v", group, device, err)
+			}
+		}
+	}
+
+	for _, socket := range s.sockets {
+		s.wg.Add(1)
+		go s.read(socket)
+	}
+	go func() {
+		s.wg.Wait()
+		close(s.packets)
+	}()
+	return s, nil
+}
+
+// frameSize returns the smallest power of two that holds snaplen bytes and is
+// at least a page, or, if that does not divide the block size, the largest
+// power of two that does. Under TPACKET_V3 packets are packed into the blocks
+// regardless of the frame size, so a smaller frame does not truncate them; the
+// block size, a multiple of the page size, does.
+func frameSize(snaplen, pageSize, blockSize int) int {
+	size := pageSize
+	for size < snaplen {
+		size <<= 1
+	}
+	for blockSize%size != 0 && size > pageSize {
+		size >>= 1
+	}
+	return size
+}
+
+// deviceLinkType returns the type of the frames delivered by device, which is
+// Ethernet unless the kernel says otherwise.
+func deviceLinkType(device string) layers.LinkType {
+	data, err := ioutil.ReadFile("/sys/class/net/" + device + "/type")
+	if err != nil {
+		return layers.LinkTypeEthernet
+	}
+	switch t, _ := strconv.Atoi(strings.TrimSpace(string(data))); t {
+	case arphrdIEEE80211:
+		return layers.LinkTypeIEEE802_11
+	case arphrdIEEE80211Radiotap:
+		return layers.LinkTypeIEEE80211Radio
+	default:
+		return layers.LinkTypeEthernet
+	}
+}
+
+// read delivers the packets read from a single socket.
+func (s *afPacket) read(socket *afpacket.TPacket) {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+
+		// The data is copied out of the ring buffer, since the packet
+		// outlives the next read.
+		data, ci, err := socket.ReadPacketData()
+		if err == afpacket.ErrTimeout || err == afpacket.ErrPoll {
+			continue
+		} else if err != nil {
+			select {
+			case <-s.done:
+			default:
+				log.Println(err)
+			}
+			return
+		}
+
+		packet := gopacket.NewPacket(data, s.linkType, gopacket.Default)
+		m := packet.Metadata()
+		m.CaptureInfo = ci
+		m.Truncated = m.Truncated || ci.CaptureLength < ci.Length
+
+		p := Packet{packet, s.device, s.linkType, func(accept bool) {
+			if !accept || s.rewrite == nil {
+				return
+			}
+			out, err := s.rewrite(packet)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			if err = socket.WritePacketData(out); err != nil {
+				log.Println(err)
+			}
+		}}
+		select {
+		case s.packets <- p:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *afPacket) Packets() <-chan Packet {
+	return s.packets
+}
+
+func (s *afPacket) LinkType() layers.LinkType {
+	return s.linkType
+}
+
+// Stats returns the statistics of all sockets of the device together.
+func (s *afPacket) Stats() ([]Stats, error) {
+	stats := Stats{Interface: s.device}
+	for _, socket := range s.sockets {
+		_, v3, err := socket.SocketStats()
+		if err != nil {
+			return nil, err
+		}
+		stats.Received += uint64(v3.Packets())
+		stats.Dropped += uint64(v3.Drops())
+	}
+	return []Stats{stats}, nil
+}
+
+// Close waits for the readers to notice that the source is closed, which takes
+// at most the poll timeout, and then releases the ring buffers.
+func (s *afPacket) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+		s.closeSockets()
+	})
+	return nil
+}
+
+func (s *afPacket) closeSockets() {
+	for _, socket := range s.sockets {
+		socket.Close()
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package capture
+
+import (
+	"errors"
+
+	"github.com/google/gopacket"
+)
+
+// NewAFPacket returns an error: AF_PACKET sockets only exist on Linux.
+func NewAFPacket(device string, index int, snaplen int, filter string, c *AFPacketConfig,
+	rewrite func(packet gopacket.Packet) ([]byte, error)) (Source, error) {
+	return nil, errors.New("AF_PACKET is only supported on Linux")
+}
@@ -0,0 +1,24 @@
+//go:build linux
+// +build linux
+
+package capture
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrameSize(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(4096, frameSize(1500, 4096, 1<<20))
+	assert.Equal(4096, frameSize(4096, 4096, 1<<20))
+	assert.Equal(16384, frameSize(9000, 4096, 1<<20))
+	assert.Equal(65536, frameSize(65535, 4096, 1<<20))
+	assert.Equal(65536, frameSize(65535, 65536, 1<<20))
+	// Blocks smaller than the frame, or not a power of two, get smaller
+	// frames.
+	assert.Equal(8192, frameSize(65535, 4096, 24576))
+	assert.Equal(16384, frameSize(65535, 4096, 16384))
+	assert.Equal(4096, frameSize(65535, 4096, 4096))
+}
@@ -0,0 +1,105 @@
+package capture
+
+import (
+	"log"
+	"sync"
+)
+
+// verdicts is the part of a netfilter queue that receives the verdicts.
+type verdicts interface {
+	accept(id uint32) error
+	drop(id uint32) error
+	// acceptBatch accepts all packets up to and including id that have
+	// not received a verdict yet.
+	acceptBatch(id uint32) error
+}
+
+// A pending packet has been delivered but not yet released from its queue.
+type pending struct {
+	id       uint32
+	accepted bool
+}
+
+// The batcher struct combines the verdicts for consecutive accepted packets
+// of a queue into one. The workers inspect the packets of different flows in
+// parallel, so verdicts arrive out of order: accepted packets are held back
+// until all packets that arrived before them have been decided on. Drops are
+// passed on right away.
+type batcher struct {
+	verdicts verdicts
+	size     int // Zero or one disables batching.
+
+	mutex   sync.Mutex
+	pending []pending // In order of arrival.
+}
+
+func newBatcher(v verdicts, size int) *batcher {
+	return &batcher{verdicts: v, size: size}
+}
+
+// add registers a packet before it is delivered.
+func (b *batcher) add(id uint32) {
+	if b.size <= 1 {
+		return
+	}
+	b.mutex.Lock()
+	b.pending = append(b.pending, pending{id: id})
+	b.mutex.Unlock()
+}
+
+// verdict records the verdict for a packet and passes on what it can.
+func (b *batcher) verdict(id uint32, accept bool) {
+	var err error
+	if b.size <= 1 {
+		if accept {
+			err = b.verdicts.accept(id)
+		} else {
+			err = b.verdicts.drop(id)
+		}
+		if err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for i := range b.pending {
+		if b.pending[i].id != id {
+			continue
+		}
+		if accept {
+			b.pending[i].accepted = true
+		} else {
+			b.pending = append(b.pending[:i], b.pending[i+1:]...)
+			if err = b.verdicts.drop(id); err != nil {
+				log.Println(err)
+			}
+		}
+		break
+	}
+	b.release(false)
+}
+
+// flush passes on the verdicts for all accepted packets that are no longer
+// waiting for an earlier packet, even if they do not fill a batch.
+func (b *batcher) flush() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.release(true)
+}
+
+func (b *batcher) release(force bool) {
+	n := 0
+	for n < len(b.pending) && b.pending[n].accepted {
+		n++
+	}
+	if n == 0 || (n < b.size && !force) {
+		return
+	}
+
+	if err := b.verdicts.acceptBatch(b.pending[n-1].id); err != nil {
+		log.Println(err)
+	}
+	b.pending = b.pending[n:]
+}
@@ -0,0 +1,66 @@
+package capture
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recorder records the verdicts passed on by a batcher.
+type recorder []string
+
+func (r *recorder) accept(id uint32) error {
+	*r = append(*r, fmt.Sprint("accept ", id))
+	return nil
+}
+
+func (r *recorder) drop(id uint32) error {
+	*r = append(*r, fmt.Sprint("drop ", id))
+	return nil
+}
+
+func (r *recorder) acceptBatch(id uint32) error {
+	*r = append(*r, fmt.Sprint("accept up to ", id))
+	return nil
+}
+
+func TestNoBatching(t *testing.T) {
+	r := &recorder{}
+	b := newBatcher(r, 1)
+	b.add(1)
+	b.add(2)
+	b.verdict(2, false)
+	b.verdict(1, true)
+
+	assert.Equal(t, &recorder{"drop 2", "accept 1"}, r)
+}
+
+func TestBatching(t *testing.T) {
+	r := &recorder{}
+	b := newBatcher(r, 3)
+	for id := uint32(1); id <= 6; id++ {
+		b.add(id)
+	}
+
+	assert := assert.New(t)
+	// Packet 1 holds back the accepted packets after it.
+	b.verdict(2, true)
+	b.verdict(3, true)
+	b.verdict(4, false)
+	assert.Equal(&recorder{"drop 4"}, r)
+
+	// Packets 1 to 3 fill a batch.
+	b.verdict(1, true)
+	assert.Equal(&recorder{"drop 4", "accept up to 3"}, r)
+
+	// Packet 5 does not fill a batch, until it is flushed.
+	b.verdict(5, true)
+	assert.Equal(&recorder{"drop 4", "accept up to 3"}, r)
+	b.flush()
+	assert.Equal(&recorder{"drop 4", "accept up to 3", "accept up to 5"}, r)
+
+	// Packet 6 is still pending.
+	b.flush()
+	assert.Equal(1, len(b.pending))
+}
@@ -0,0 +1,234 @@
+package capture
+
+import (
+	"container/list"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+// The sides of a bridge.
+const (
+	inside = iota
+	outside
+)
+
+// MACAge is the time after which a learned MAC address is forgotten, so that
+// hosts can move from one side of a bridge to the other.
+const MACAge = 5 * time.Minute
+
+// MaxMACs is the number of MAC addresses a bridge remembers. When there are
+// more, the address that has not been seen for longest is forgotten, so that a
+// flood of frames from random addresses cannot exhaust the memory.
+const MaxMACs = 16384
+
+// The bridge struct forwards the frames it captures on either of its
+// interfaces to the other, once they have been accepted.
+type bridge struct {
+	devices [2]string
+	handles [2]*pcap.Handle
+	writers [2]packetDataWriter // The handles, as the frames are written to them.
+	table   *macTable
+	rewrite func(packet gopacket.Packet) ([]byte, error)
+	packets chan Packet
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewBridge returns an inline Source that bridges two Ethernet interfaces, so
+// that the IPS can sit as a bump in the wire. The handles capture on the named
+// devices, and frames are tagged with the device they arrived on. A frame
+// captured on one handle is written to the other once it has been accepted,
+// unless its destination was learned to be on the side it came from. If
+// rewrite is not nil, it returns the data that is written instead of the
+// frame. Both handles should be opened in promiscuous mode; only incoming
+// frames are captured, so that forwarded frames are not seen twice.
+func NewBridge(insideDevice string, insideHandle *pcap.Handle, outsideDevice string, outsideHandle *pcap.Handle,
+	rewrite func(packet gopacket.Packet) ([]byte, error)) (Source, error) {
+	b := &bridge{
+		devices: [2]string{insideDevice, outsideDevice},
+		handles: [2]*pcap.Handle{insideHandle, outsideHandle},
+		writers: [2]packetDataWriter{insideHandle, outsideHandle},
+		rewrite: rewrite,
+		table:   newMACTable(MACAge, MaxMACs),
+		packets: make(chan Packet),
+		done:    make(chan struct{}),
+	}
+	for _, handle := range b.handles {
+		if err := handle.SetDirection(pcap.DirectionIn); err != nil {
+			return nil, err
+		}
+	}
+
+	for side := range b.handles {
+		b.wg.Add(1)
+		go b.read(side)
+	}
+	go func() {
+		b.wg.Wait()
+		close(b.packets)
+	}()
+	return b, nil
+}
+
+// A packetDataWriter writes frames onto a device, such as a pcap.Handle.
+type packetDataWriter interface {
+	WritePacketData(data []byte) error
+}
+
+// read delivers the frames captured on one side of the bridge.
+func (b *bridge) read(side int) {
+	defer b.wg.Done()
+
+	packetSource := gopacket.NewPacketSource(b.handles[side], layers.LinkTypeEthernet)
+	for packet := range packetSource.Packets() {
+		if len(packet.Data()) < 14 {
+			continue
+		}
+		b.table.learn(net.HardwareAddr(packet.Data()[6:12]), side)
+
+		p := Packet{packet, b.devices[side], layers.LinkTypeEthernet, b.verdict(packet, side)}
+		select {
+		case b.packets <- p:
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// verdict returns the Verdict of a frame captured on side, which forwards it
+// to the other side once it has been accepted.
+func (b *bridge) verdict(packet gopacket.Packet, side int) func(accept bool) {
+	return func(accept bool) {
+		if !accept {
+			return
+		}
+		dst := net.HardwareAddr(packet.Data()[0:6])
+		if location, ok := b.table.lookup(dst); ok && location == side {
+			return
+		}
+		out := packet.Data()
+		if b.rewrite != nil {
+			var err error
+			if out, err = b.rewrite(packet); err != nil {
+				log.Println(err)
+				return
+			}
+		}
+		if err := b.writers[1-side].WritePacketData(out); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func (b *bridge) Packets() <-chan Packet {
+	return b.packets
+}
+
+func (b *bridge) LinkType() layers.LinkType {
+	return layers.LinkTypeEthernet
+}
+
+func (b *bridge) Stats() ([]Stats, error) {
+	var stats []Stats
+	for side, handle := range b.handles {
+		s, err := handleStats(b.devices[side], handle)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+	return stats, nil
+}
+
+func (b *bridge) Close() error {
+	close(b.done)
+	for _, handle := range b.handles {
+		handle.Close()
+	}
+	return nil
+}
+
+// A macTable remembers on which side of the bridge a MAC address was last
+// seen as the source of a frame.
+type macTable struct {
+	age time.Duration
+	max int
+	now func() time.Time
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // The macEntries, the most recently seen first.
+}
+
+type macEntry struct {
+	mac  string
+	side int
+	seen time.Time
+}
+
+func newMACTable(age time.Duration, max int) *macTable {
+	return &macTable{
+		age:     age,
+		max:     max,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// learn records that mac was seen on side, and forgets the addresses that
+// have not been seen recently, or, if there are too many, the one that has not
+// been seen for longest. Group addresses never appear as the source of a valid
+// frame and are ignored.
+func (t *macTable) learn(mac net.HardwareAddr, side int) {
+	if len(mac) == 0 || mac[0]&0x01 != 0 {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.now()
+	if element, ok := t.entries[string(mac)]; ok {
+		entry := element.Value.(*macEntry)
+		entry.side, entry.seen = side, now
+		t.order.MoveToFront(element)
+	} else {
+		t.entries[string(mac)] = t.order.PushFront(&macEntry{string(mac), side, now})
+	}
+
+	for element := t.order.Back(); element != nil; element = t.order.Back() {
+		if now.Sub(element.Value.(*macEntry).seen) <= t.age && t.order.Len() <= t.max {
+			break
+		}
+		t.forget(element)
+	}
+}
+
+func (t *macTable) forget(element *list.Element) {
+	delete(t.entries, element.Value.(*macEntry).mac)
+	t.order.Remove(element)
+}
+
+// lookup returns the side mac was last seen on, unless it has not been seen
+// recently. Group addresses are never found, so that they are flooded.
+func (t *macTable) lookup(mac net.HardwareAddr) (int, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	element, ok := t.entries[string(mac)]
+	if !ok {
+		return 0, false
+	}
+	entry := element.Value.(*macEntry)
+	if t.now().Sub(entry.seen) > t.age {
+		t.forget(element)
+		return 0, false
+	}
+	return entry.side, true
+}
@@ -0,0 +1,95 @@
+package capture
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Hjdskes/ET4397IN/rewrite"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMACTable(t *testing.T) {
+	now := time.Unix(0, 0)
+	table := newMACTable(time.Minute, 2)
+	table.now = func() time.Time { return now }
+
+	host, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	broadcast, _ := net.ParseMAC("ff:ff:ff:ff:ff:ff")
+
+	assert := assert.New(t)
+	_, ok := table.lookup(host)
+	assert.False(ok)
+
+	table.learn(host, inside)
+	side, ok := table.lookup(host)
+	assert.True(ok)
+	assert.Equal(inside, side)
+
+	// The host moved.
+	table.learn(host, outside)
+	side, _ = table.lookup(host)
+	assert.Equal(outside, side)
+
+	// Group addresses are never learned.
+	table.learn(broadcast, inside)
+	_, ok = table.lookup(broadcast)
+	assert.False(ok)
+
+	// Entries are forgotten after a while.
+	now = now.Add(2 * time.Minute)
+	_, ok = table.lookup(host)
+	assert.False(ok)
+
+	// Learning forgets the entries that are too old, and the ones that
+	// have not been seen for longest when there are too many.
+	a, _ := net.ParseMAC("02:00:00:00:00:0a")
+	b, _ := net.ParseMAC("02:00:00:00:00:0b")
+	c, _ := net.ParseMAC("02:00:00:00:00:0c")
+	table.learn(a, inside)
+	now = now.Add(2 * time.Minute)
+	table.learn(b, inside)
+	assert.Len(table.entries, 1)
+	table.learn(a, inside)
+	table.learn(b, outside)
+	table.learn(c, outside)
+	assert.Len(table.entries, 2)
+	_, ok = table.lookup(a)
+	assert.False(ok)
+	side, ok = table.lookup(b)
+	assert.True(ok)
+	assert.Equal(outside, side)
+}
+
+// A frameRecorder records the frames written to it.
+type frameRecorder struct {
+	frames [][]byte
+}
+
+func (r *frameRecorder) WritePacketData(data []byte) error {
+	r.frames = append(r.frames, data)
+	return nil
+}
+
+func TestBridgeSTP(t *testing.T) {
+	var in, out frameRecorder
+	rewriter := rewrite.New([]rewrite.Rule{rewrite.Forward(net.ParseIP("10.0.0.1"))}, time.Minute)
+	b := &bridge{writers: [2]packetDataWriter{&in, &out}, table: newMACTable(MACAge, MaxMACs), rewrite: rewriter.Rewrite}
+
+	// A configuration BPDU, carried by 802.3 and LLC, of which gopacket
+	// decodes the STP layer but cannot serialize it again.
+	data := []byte{
+		0x01, 0x80, 0xc2, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x26,
+		0x42, 0x42, 0x03,
+	}
+	data = append(data, make([]byte, 35)...)
+	packet := gopacket.NewPacket(data, layers.LinkTypeEthernet, gopacket.Default)
+	assert := assert.New(t)
+	assert.NotNil(packet.Layer(layers.LayerTypeSTP))
+
+	b.verdict(packet, inside)(true)
+	assert.Empty(in.frames)
+	assert.Equal([][]byte{data}, out.frames)
+}
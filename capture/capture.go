@@ -0,0 +1,200 @@
+// This package implements the sources of the packets that are inspected. A
+// passive source, such as a pcap handle, sees copies of packets that have
+// already passed the kernel; an inline source holds every packet until it has
+// received its verdict, so dropped packets never reach their destination.
+package capture
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Hjdskes/ET4397IN/config"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+// A Packet is a captured packet awaiting its verdict. Verdict must be called
+// exactly once, with true to let the packet through and false to drop it.
+type Packet struct {
+	gopacket.Packet
+	// Interface is the name of the device the packet was captured on, or
+	// the path of the file it was read from. It may be empty if unknown.
+	Interface string
+	// LinkType is the type of the first layer of the packet.
+	LinkType layers.LinkType
+	Verdict  func(accept bool)
+}
+
+// A Source delivers captured packets. The channel returned by Packets is
+// closed when the source runs out of packets.
+type Source interface {
+	Packets() <-chan Packet
+	// LinkType returns the type of the first layer of the packets.
+	LinkType() layers.LinkType
+	// Close stops capturing. Verdicts given afterwards are ignored.
+	Close() error
+}
+
+func init() {
+	config.Register("nfqueue", func() interface{} {
+		return &NFQueueConfig{
+			Queues:       []uint16{0},
+			MaxQueueLen:  1024,
+			BatchTimeout: config.Duration{Duration: 10 * time.Millisecond},
+		}
+	})
+	config.Register("afpacket", func() interface{} {
+		return &AFPacketConfig{
+			Sockets:     1,
+			FanoutType:  "hash",
+			BlockSize:   1 << 20,
+			NumBlocks:   64,
+			PollTimeout: config.Duration{Duration: 100 * time.Millisecond},
+		}
+	})
+}
+
+// A Stats is a count of the packets the kernel captured on an interface.
+type Stats struct {
+	Interface string
+	// The number of packets received by the kernel.
+	Received uint64
+	// The number of packets the kernel dropped because its buffers were
+	// full.
+	Dropped uint64
+	// The number of packets dropped by the interface or its driver, if
+	// known.
+	InterfaceDropped uint64
+}
+
+// A StatsSource is a Source that can report the statistics of the kernel, per
+// interface.
+type StatsSource interface {
+	Source
+	Stats() ([]Stats, error)
+}
+
+// handleStats returns the statistics of a pcap handle capturing on device.
+func handleStats(device string, handle *pcap.Handle) (Stats, error) {
+	s, err := handle.Stats()
+	if err != nil {
+		return Stats{}, err
+	}
+	return Stats{
+		Interface:        device,
+		Received:         uint64(s.PacketsReceived),
+		Dropped:          uint64(s.PacketsDropped),
+		InterfaceDropped: uint64(s.PacketsIfDropped),
+	}, nil
+}
+
+// NFQueueConfig is the configuration section of the NFQUEUE source.
+type NFQueueConfig struct {
+	// The netfilter queues to read packets from, e.g. the range given to
+	// iptables with --queue-balance.
+	Queues []uint16 `json:"queues"`
+	// Whether the kernel accepts packets when a queue is full. Otherwise,
+	// they are dropped.
+	FailOpen bool `json:"fail-open"`
+	// The maximum number of packets the kernel holds in each queue.
+	MaxQueueLen uint32 `json:"max-queue-length"`
+	// The number of consecutive accepted packets to give a single verdict
+	// for. Zero or one gives a verdict for every packet on its own.
+	BatchSize int `json:"batch-size"`
+	// The maximum time to hold back the verdicts of an incomplete batch.
+	BatchTimeout config.Duration `json:"batch-timeout"`
+}
+
+func (c *NFQueueConfig) Validate() []config.Problem {
+	var ps []config.Problem
+	if len(c.Queues) == 0 {
+		ps = append(ps, config.Problem{Path: "queues", Message: "At least one queue is required"})
+	}
+	seen := make(map[uint16]bool)
+	for i, q := range c.Queues {
+		if seen[q] {
+			ps = append(ps, config.Problem{Path: config.Index("queues", i), Message: fmt.Sprintf("Duplicate queue: %d", q)})
+		}
+		seen[q] = true
+	}
+	if c.MaxQueueLen == 0 {
+		ps = append(ps, config.Problem{Path: "max-queue-length", Message: "Must be at least 1, found 0"})
+	}
+	if c.BatchSize < 0 {
+		ps = append(ps, config.Problem{Path: "batch-size", Message: fmt.Sprintf("Must not be negative, found %d", c.BatchSize)})
+	}
+	if c.BatchTimeout.Duration <= 0 {
+		ps = append(ps, config.Problem{Path: "batch-timeout", Message: "Must be positive, found " + c.BatchTimeout.String()})
+	}
+	return ps
+}
+
+// AFPacketConfig is the configuration section of the AF_PACKET capture
+// backend.
+type AFPacketConfig struct {
+	// The number of sockets, each with its own ring buffer and reader,
+	// that share the packets of every device.
+	Sockets int `json:"sockets"`
+	// The fanout group the sockets of the first device join; those of the
+	// next devices join the groups after it. Other processes that join the
+	// same group share the packets with this one. Zero picks a group of
+	// this process alone.
+	FanoutGroup uint16 `json:"fanout-group"`
+	// How the packets are spread over the sockets of a fanout group: hash
+	// (by flow), lb (round robin), cpu, rollover, random or queue-mapping.
+	FanoutType string `json:"fanout-type"`
+	// The size of each block of the ring buffer in bytes, a multiple of
+	// the page size. Larger packets are truncated.
+	BlockSize int `json:"block-size"`
+	// The number of blocks in the ring buffer of each socket.
+	NumBlocks int `json:"num-blocks"`
+	// How long a reader waits for a block before checking whether the
+	// source is closed.
+	PollTimeout config.Duration `json:"poll-timeout"`
+}
+
+// FanoutTypes are the valid values of AFPacketConfig.FanoutType.
+var FanoutTypes = []string{"hash", "lb", "cpu", "rollover", "random", "queue-mapping"}
+
+func (c *AFPacketConfig) Validate() []config.Problem {
+	var ps []config.Problem
+	if c.Sockets < 1 {
+		ps = append(ps, config.Problem{Path: "sockets", Message: fmt.Sprintf("Must be at least 1, found %d", c.Sockets)})
+	}
+	valid := false
+	for _, t := range FanoutTypes {
+		valid = valid || t == c.FanoutType
+	}
+	if !valid {
+		ps = append(ps, config.Problem{Path: "fanout-type", Message: fmt.Sprintf("Invalid fanout type %q, must be one of %s", c.FanoutType, strings.Join(FanoutTypes, ", "))})
+	}
+	if pageSize := os.Getpagesize(); c.BlockSize <= 0 || c.BlockSize%pageSize != 0 {
+		ps = append(ps, config.Problem{Path: "block-size", Message: fmt.Sprintf("Must be a positive multiple of the page size %d, found %d", pageSize, c.BlockSize)})
+	}
+	if c.NumBlocks < 1 {
+		ps = append(ps, config.Problem{Path: "num-blocks", Message: fmt.Sprintf("Must be at least 1, found %d", c.NumBlocks)})
+	}
+	if c.PollTimeout.Duration <= 0 {
+		ps = append(ps, config.Problem{Path: "poll-timeout", Message: "Must be positive, found " + c.PollTimeout.String()})
+	}
+	return ps
+}
+
+// decodeIP decodes a packet that starts with its IP header, as delivered by
+// netfilter.
+func decodeIP(data []byte, timestamp time.Time) gopacket.Packet {
+	var first gopacket.Decoder = layers.LayerTypeIPv4
+	if len(data) > 0 && data[0]>>4 == 6 {
+		first = layers.LayerTypeIPv6
+	}
+
+	packet := gopacket.NewPacket(data, first, gopacket.Default)
+	m := packet.Metadata()
+	m.Timestamp = timestamp
+	m.CaptureLength = len(data)
+	m.Length = len(data)
+	return packet
+}
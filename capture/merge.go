@@ -0,0 +1,83 @@
+package capture
+
+import (
+	"sync"
+
+	"github.com/google/gopacket/layers"
+)
+
+// The merged struct delivers the packets of several sources as they arrive.
+type merged struct {
+	sources []Source
+	packets chan Packet
+	done    chan struct{}
+}
+
+// Merge returns a Source delivering the packets of all sources, in the order
+// in which they arrive; use the Interface of a packet to tell the sources
+// apart. Its link type is that of the first source. The channel of packets is
+// closed once those of all sources are.
+func Merge(sources ...Source) Source {
+	if len(sources) == 1 {
+		return sources[0]
+	}
+
+	m := &merged{
+		sources: sources,
+		packets: make(chan Packet),
+		done:    make(chan struct{}),
+	}
+	var wg sync.WaitGroup
+	for _, s := range sources {
+		wg.Add(1)
+		go func(s Source) {
+			defer wg.Done()
+			for p := range s.Packets() {
+				select {
+				case m.packets <- p:
+				case <-m.done:
+					return
+				}
+			}
+		}(s)
+	}
+	go func() {
+		wg.Wait()
+		close(m.packets)
+	}()
+	return m
+}
+
+func (m *merged) Packets() <-chan Packet {
+	return m.packets
+}
+
+func (m *merged) LinkType() layers.LinkType {
+	return m.sources[0].LinkType()
+}
+
+// Stats returns the statistics of all sources that report them.
+func (m *merged) Stats() ([]Stats, error) {
+	var stats []Stats
+	for _, s := range m.sources {
+		if s, ok := s.(StatsSource); ok {
+			ss, err := s.Stats()
+			if err != nil {
+				return nil, err
+			}
+			stats = append(stats, ss...)
+		}
+	}
+	return stats, nil
+}
+
+func (m *merged) Close() error {
+	close(m.done)
+	var err error
+	for _, s := range m.sources {
+		if cerr := s.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,172 @@
+//go:build linux
+// +build linux
+
+package capture
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/florianl/go-nfqueue"
+	"github.com/google/gopacket/layers"
+)
+
+// The nfQueue struct delivers the packets from one or more netfilter queues.
+type nfQueue struct {
+	queues  []*queue
+	devices sync.Map // Names of the devices, by index.
+	packets chan Packet
+	cancel  context.CancelFunc
+	done    chan struct{}
+	once    sync.Once
+}
+
+// A queue is a single netfilter queue.
+type queue struct {
+	number  uint16
+	nf      *nfqueue.Nfqueue
+	batcher *batcher
+}
+
+func (q *queue) accept(id uint32) error {
+	return q.nf.SetVerdict(id, nfqueue.NfAccept)
+}
+
+func (q *queue) drop(id uint32) error {
+	return q.nf.SetVerdict(id, nfqueue.NfDrop)
+}
+
+func (q *queue) acceptBatch(id uint32) error {
+	return q.nf.SetVerdictBatch(id, nfqueue.NfAccept)
+}
+
+// NewNFQueue returns an inline Source reading from the netfilter queues in c.
+// The kernel holds every packet until it has received its verdict, so packets
+// are really dropped. Packets are sent to the queues by an iptables rule such
+// as:
+//
+//	iptables -A FORWARD -j NFQUEUE --queue-num 0
+func NewNFQueue(c *NFQueueConfig) (Source, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &nfQueue{
+		packets: make(chan Packet),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+
+	var flags uint32
+	if c.FailOpen {
+		flags = nfqueue.NfQaCfgFlagFailOpen
+	}
+	for _, number := range c.Queues {
+		nf, err := nfqueue.Open(&nfqueue.Config{
+			NfQueue:      number,
+			MaxPacketLen: 0xFFFF,
+			MaxQueueLen:  c.MaxQueueLen,
+			Copymode:     nfqueue.NfQnlCopyPacket,
+			Flags:        flags,
+			WriteTimeout: 15 * time.Millisecond,
+		})
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("Can't open netfilter queue %d: %v", number, err)
+		}
+
+		q := &queue{number: number, nf: nf}
+		q.batcher = newBatcher(q, c.BatchSize)
+		s.queues = append(s.queues, q)
+		if err := nf.Register(ctx, s.hook(q)); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("Can't read from netfilter queue %d: %v", number, err)
+		}
+	}
+
+	// Release incomplete batches in time.
+	if c.BatchSize > 1 {
+		go func() {
+			ticker := time.NewTicker(c.BatchTimeout.Duration)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					for _, q := range s.queues {
+						q.batcher.flush()
+					}
+				case <-s.done:
+					return
+				}
+			}
+		}()
+	}
+	return s, nil
+}
+
+// hook returns the function called for every packet in the queue q.
+func (s *nfQueue) hook(q *queue) nfqueue.HookFunc {
+	return func(a nfqueue.Attribute) int {
+		if a.PacketID == nil || a.Payload == nil {
+			return 0
+		}
+		id := *a.PacketID
+		timestamp := time.Now()
+		if a.Timestamp != nil {
+			timestamp = *a.Timestamp
+		}
+
+		var device string
+		if a.InDev != nil {
+			device = s.device(*a.InDev)
+		}
+
+		q.batcher.add(id)
+		p := Packet{decodeIP(*a.Payload, timestamp), device, layers.LinkTypeRaw, func(accept bool) {
+			q.batcher.verdict(id, accept)
+		}}
+		select {
+		case s.packets <- p:
+		case <-s.done:
+		}
+		return 0
+	}
+}
+
+// device returns the name of the device with the given index.
+func (s *nfQueue) device(index uint32) string {
+	if name, ok := s.devices.Load(index); ok {
+		return name.(string)
+	}
+	iface, err := net.InterfaceByIndex(int(index))
+	if err != nil {
+		return ""
+	}
+	s.devices.Store(index, iface.Name)
+	return iface.Name
+}
+
+func (s *nfQueue) Packets() <-chan Packet {
+	return s.packets
+}
+
+func (s *nfQueue) LinkType() layers.LinkType {
+	return layers.LinkTypeRaw
+}
+
+// Close releases the held back verdicts and closes the queues. Packets still
+// in the queues are discarded by the kernel.
+func (s *nfQueue) Close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.done)
+		s.cancel()
+		for _, q := range s.queues {
+			q.batcher.flush()
+			if cerr := q.nf.Close(); err == nil {
+				err = cerr
+			}
+		}
+	})
+	return err
+}
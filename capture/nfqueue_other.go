@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package capture
+
+import "errors"
+
+// NewNFQueue returns an error: netfilter queues only exist on Linux.
+func NewNFQueue(c *NFQueueConfig) (Source, error) {
+	return nil, errors.New("NFQUEUE is only supported on Linux")
+}
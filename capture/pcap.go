@@ -0,0 +1,70 @@
+package capture
+
+import (
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+// The pcapSource struct delivers the packets read from a pcap handle.
+type pcapSource struct {
+	handle  *pcap.Handle
+	device  string
+	packets chan Packet
+	done    chan struct{}
+}
+
+// NewPcap returns a passive Source reading from handle, which captures on
+// device. Its packets have already passed the kernel, so a verdict cannot drop
+// them: accepted packets are handed to forward, if it is not nil, and dropped
+// ones are ignored.
+func NewPcap(handle *pcap.Handle, device string, forward func(packet gopacket.Packet)) Source {
+	s := &pcapSource{
+		handle:  handle,
+		device:  device,
+		packets: make(chan Packet),
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		defer close(s.packets)
+		linkType := handle.LinkType()
+		packetSource := gopacket.NewPacketSource(handle, linkType)
+		for packet := range packetSource.Packets() {
+			packet := packet
+			p := Packet{packet, device, linkType, func(accept bool) {
+				if accept && forward != nil {
+					forward(packet)
+				}
+			}}
+			select {
+			case s.packets <- p:
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+func (s *pcapSource) Packets() <-chan Packet {
+	return s.packets
+}
+
+func (s *pcapSource) LinkType() layers.LinkType {
+	return s.handle.LinkType()
+}
+
+func (s *pcapSource) Stats() ([]Stats, error) {
+	stats, err := handleStats(s.device, s.handle)
+	if err != nil {
+		return nil, err
+	}
+	return []Stats{stats}, nil
+}
+
+func (s *pcapSource) Close() error {
+	close(s.done)
+	s.handle.Close()
+	return nil
+}
@@ -0,0 +1,225 @@
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+// LoopGap is the time between the last packet of the capture files and the
+// first packet of their next replay, see ReplayOptions.Loop.
+const LoopGap = time.Second
+
+// ReplayOptions control how capture files are replayed.
+type ReplayOptions struct {
+	// Speed is the factor by which the replay is sped up relative to the
+	// capture, see ParseSpeed. Zero replays as fast as possible.
+	Speed float64
+	// Loop replays the files over and over, until the source is closed.
+	// The timestamps of every replay follow those of the one before it,
+	// LoopGap after its last packet, so that time keeps moving forward.
+	Loop bool
+	// Filter is a BPF applied to the files, if not empty.
+	Filter string
+	// Inject, if not nil, is a handle on which all replayed packets are
+	// written as they are replayed, for instance to test other sensors.
+	Inject *pcap.Handle
+}
+
+// ParseSpeed parses a replay speed such as "1x", "0.5x", "10" or "max", which
+// replays as fast as possible and returns zero.
+func ParseSpeed(s string) (float64, error) {
+	if s == "max" {
+		return 0, nil
+	}
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(s, "x"), 64)
+	if err != nil || speed <= 0 {
+		return 0, fmt.Errorf("Invalid replay speed: %s", s)
+	}
+	return speed, nil
+}
+
+// The replay struct delivers the packets of one or more capture files.
+type replay struct {
+	paths    []string
+	options  ReplayOptions
+	linkType layers.LinkType
+	packets  chan Packet
+	done     chan struct{}
+	stopped  chan struct{} // Closed once run has returned.
+}
+
+// NewReplay returns a passive Source reading the capture files at paths,
+// merged in the order of their timestamps. Its packets are tagged with the
+// path of their file. Unless the speed is zero, packets are delivered at the
+// pace at which they were captured. Verdicts have no effect.
+func NewReplay(paths []string, options ReplayOptions) (Source, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("No capture files to replay")
+	}
+
+	r := &replay{
+		paths:   paths,
+		options: options,
+		packets: make(chan Packet),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	files, err := r.open()
+	if err != nil {
+		return nil, err
+	}
+	r.linkType = files[0].linkType
+	go r.run(files)
+	return r, nil
+}
+
+// A file is a capture file that is being replayed.
+type file struct {
+	path     string
+	handle   *pcap.Handle
+	linkType layers.LinkType
+	packets  chan gopacket.Packet
+	head     gopacket.Packet // The next packet, or nil at the end of the file.
+}
+
+// open opens all files and reads their first packets.
+func (r *replay) open() ([]*file, error) {
+	var files []*file
+	for _, path := range r.paths {
+		handle, err := pcap.OpenOffline(path)
+		if err == nil && r.options.Filter != "" {
+			if err = handle.SetBPFFilter(r.options.Filter); err != nil {
+				handle.Close()
+			}
+		}
+		if err != nil {
+			closeFiles(files)
+			return nil, err
+		}
+
+		f := &file{path: path, handle: handle, linkType: handle.LinkType()}
+		f.packets = gopacket.NewPacketSource(handle, f.linkType).Packets()
+		f.head = <-f.packets
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+func closeFiles(files []*file) {
+	for _, f := range files {
+		f.handle.Close()
+	}
+}
+
+// next returns the earliest packet of all files and its file, or nil at the
+// end of all files.
+func next(files []*file) (gopacket.Packet, *file) {
+	var earliest *file
+	for _, f := range files {
+		if f.head == nil {
+			continue
+		}
+		if earliest == nil || f.head.Metadata().Timestamp.Before(earliest.head.Metadata().Timestamp) {
+			earliest = f
+		}
+	}
+	if earliest == nil {
+		return nil, nil
+	}
+
+	packet := earliest.head
+	earliest.head = <-earliest.packets
+	return packet, earliest
+}
+
+func (r *replay) run(files []*file) {
+	defer close(r.stopped)
+	defer close(r.packets)
+
+	var start time.Time // Wall time at which the first packet was replayed.
+	var base time.Time  // Timestamp of the first packet.
+	var offset time.Duration
+	for {
+		var first, last time.Time
+		for {
+			packet, f := next(files)
+			if packet == nil {
+				break
+			}
+
+			m := packet.Metadata()
+			if first.IsZero() {
+				first = m.Timestamp
+			}
+			last = m.Timestamp
+			m.Timestamp = m.Timestamp.Add(offset)
+
+			if start.IsZero() {
+				start, base = time.Now(), m.Timestamp
+			} else if r.options.Speed > 0 {
+				// Wait until the packet is due.
+				elapsed := time.Duration(float64(m.Timestamp.Sub(base)) / r.options.Speed)
+				if wait := time.Until(start.Add(elapsed)); wait > 0 {
+					select {
+					case <-time.After(wait):
+					case <-r.done:
+						closeFiles(files)
+						return
+					}
+				}
+			}
+
+			if r.options.Inject != nil {
+				if err := r.options.Inject.WritePacketData(packet.Data()); err != nil {
+					log.Println(err)
+				}
+			}
+			select {
+			case r.packets <- Packet{packet, f.path, f.linkType, func(bool) {}}:
+			case <-r.done:
+				closeFiles(files)
+				return
+			}
+		}
+		closeFiles(files)
+
+		if !r.options.Loop || first.IsZero() {
+			return
+		}
+		offset += last.Sub(first) + LoopGap
+
+		var err error
+		if files, err = r.open(); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+}
+
+func (r *replay) Packets() <-chan Packet {
+	return r.packets
+}
+
+func (r *replay) LinkType() layers.LinkType {
+	return r.linkType
+}
+
+// Close stops the replay, and closes the Inject handle once no more packets
+// are written to it.
+func (r *replay) Close() error {
+	close(r.done)
+	<-r.stopped
+	if r.options.Inject != nil {
+		r.options.Inject.Close()
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package capture
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSpeed(t *testing.T) {
+	assert := assert.New(t)
+	for s, expected := range map[string]float64{"1x": 1, "10x": 10, "0.5x": 0.5, "2": 2, "max": 0} {
+		speed, err := ParseSpeed(s)
+		assert.Nil(err)
+		assert.Equal(expected, speed)
+	}
+
+	for _, s := range []string{"fast", "0x", "-1x", ""} {
+		_, err := ParseSpeed(s)
+		assert.EqualError(err, "Invalid replay speed: "+s)
+	}
+}
+
+func TestReplayClose(t *testing.T) {
+	src, err := NewReplay([]string{"../functional/arp.pcap"}, ReplayOptions{Loop: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-src.Packets()
+
+	// Once Close returns, the replay has stopped and writes no more
+	// packets.
+	assert.Nil(t, src.Close())
+	select {
+	case _, ok := <-src.Packets():
+		assert.False(t, ok)
+	default:
+		t.Error("The replay is still running after Close")
+	}
+}
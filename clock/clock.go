@@ -0,0 +1,176 @@
+// This package implements the clocks the modules tell the time by. When
+// capturing live, time passes as usual; when reading a capture file, time is
+// taken from the timestamps of the packets, so that the results of an
+// analysis do not depend on how fast the file is read.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// A Clock tells the time and runs functions periodically.
+type Clock interface {
+	Now() time.Time
+	// Every calls f every d, until the returned Ticker is stopped.
+	Every(d time.Duration, f func()) Ticker
+}
+
+// A Ticker periodically calls a function, see Clock.Every.
+type Ticker interface {
+	// Reset changes the period of the ticker to d, starting now.
+	Reset(d time.Duration)
+	Stop()
+}
+
+// Wall returns the Clock of the system.
+func Wall() Clock {
+	return wall{}
+}
+
+type wall struct{}
+
+func (wall) Now() time.Time {
+	return time.Now()
+}
+
+func (wall) Every(d time.Duration, f func()) Ticker {
+	t := &wallTicker{
+		ticker: time.NewTicker(d),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		for {
+			select {
+			case <-t.ticker.C:
+				f()
+			case <-t.done:
+				return
+			}
+		}
+	}()
+	return t
+}
+
+type wallTicker struct {
+	ticker *time.Ticker
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (t *wallTicker) Reset(d time.Duration) {
+	t.ticker.Reset(d)
+}
+
+func (t *wallTicker) Stop() {
+	t.once.Do(func() {
+		t.ticker.Stop()
+		close(t.done)
+	})
+}
+
+// The Packet struct is a Clock that only advances when it is told the time of
+// the packet that is being processed. Functions passed to Every are called by
+// Advance, on its goroutine, so that they run at the same point in the stream
+// of packets every time. Until the first packet, the time is the zero time.
+type Packet struct {
+	mutex   sync.Mutex
+	now     time.Time
+	tickers []*packetTicker
+}
+
+// NewPacket creates a Packet clock.
+func NewPacket() *Packet {
+	return &Packet{}
+}
+
+func (c *Packet) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+// Advance sets the time to t and calls the functions of the tickers that are
+// due, in the order in which they are due. The time never goes back, so
+// packets that are slightly out of order do not disturb the tickers. A ticker
+// that missed several periods, for instance during a gap in the capture,
+// fires only once, like a time.Ticker.
+func (c *Packet) Advance(t time.Time) {
+	c.mutex.Lock()
+	if c.now.IsZero() {
+		// The tickers created before the first packet start now.
+		for _, ticker := range c.tickers {
+			ticker.next = t.Add(ticker.period)
+		}
+		c.now = t
+	}
+
+	for {
+		var due *packetTicker
+		for _, ticker := range c.tickers {
+			if !ticker.next.After(t) && (due == nil || ticker.next.Before(due.next)) {
+				due = ticker
+			}
+		}
+		if due == nil {
+			break
+		}
+
+		if due.next.After(c.now) {
+			c.now = due.next
+		}
+		due.next = due.next.Add(due.period)
+		if !due.next.After(t) {
+			due.next = t.Add(due.period - t.Sub(due.next)%due.period)
+		}
+
+		f := due.f
+		c.mutex.Unlock()
+		f()
+		c.mutex.Lock()
+	}
+
+	if t.After(c.now) {
+		c.now = t
+	}
+	c.mutex.Unlock()
+}
+
+func (c *Packet) Every(d time.Duration, f func()) Ticker {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	t := &packetTicker{clock: c, period: d, f: f}
+	if !c.now.IsZero() {
+		t.next = c.now.Add(d)
+	}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+type packetTicker struct {
+	clock  *Packet
+	period time.Duration
+	next   time.Time
+	f      func()
+}
+
+func (t *packetTicker) Reset(d time.Duration) {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	t.period = d
+	if !t.clock.now.IsZero() {
+		t.next = t.clock.now.Add(d)
+	}
+}
+
+func (t *packetTicker) Stop() {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	for i, ticker := range t.clock.tickers {
+		if ticker == t {
+			t.clock.tickers = append(t.clock.tickers[:i], t.clock.tickers[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPacketClock(t *testing.T) {
+	start := time.Unix(1000, 0)
+	c := NewPacket()
+
+	var fired []time.Time
+	ticker := c.Every(time.Second, func() {
+		fired = append(fired, c.Now())
+	})
+
+	assert := assert.New(t)
+	assert.True(c.Now().IsZero())
+
+	// The ticker starts at the first packet.
+	c.Advance(start)
+	c.Advance(start.Add(999 * time.Millisecond))
+	assert.Equal(0, len(fired))
+
+	c.Advance(start.Add(1500 * time.Millisecond))
+	assert.Equal([]time.Time{start.Add(time.Second)}, fired)
+	assert.Equal(start.Add(1500*time.Millisecond), c.Now())
+
+	// Time does not go back.
+	c.Advance(start)
+	assert.Equal(start.Add(1500*time.Millisecond), c.Now())
+
+	// Missed periods fire once.
+	c.Advance(start.Add(10500 * time.Millisecond))
+	assert.Equal([]time.Time{start.Add(time.Second), start.Add(2 * time.Second)}, fired)
+	c.Advance(start.Add(10900 * time.Millisecond))
+	assert.Equal(2, len(fired))
+	c.Advance(start.Add(11 * time.Second))
+	assert.Equal(3, len(fired))
+
+	ticker.Stop()
+	c.Advance(start.Add(20 * time.Second))
+	assert.Equal(3, len(fired))
+}
+
+func TestPacketClockReset(t *testing.T) {
+	start := time.Unix(1000, 0)
+	c := NewPacket()
+	c.Advance(start)
+
+	fired := 0
+	ticker := c.Every(time.Second, func() { fired++ })
+	ticker.Reset(5 * time.Second)
+
+	c.Advance(start.Add(4 * time.Second))
+	assert.Equal(t, 0, fired)
+	c.Advance(start.Add(5 * time.Second))
+	assert.Equal(t, 1, fired)
+}
@@ -3,34 +3,75 @@ package config
 import (
 	"encoding/json"
 	"io/ioutil"
+	"os"
 )
 
+// The Configuration struct holds the settings shared by the whole IPS, and one
+// section per module that declared one, see Register. In the file, every
+// section is a JSON object named after its module.
 type Configuration struct {
-	ARPBindings  map[string][]string `json:"arp-bindings"`
-	Interval     int64               `json:"interval"`
-	SynInterval  int64               `json:"syn-interval"`
-	SynThreshold int32               `json:"syn-threshold"`
-	ForwardIP    string              `json:"forward-ip"`
+	Modules   []string `json:"modules"`
+	ForwardIP string   `json:"forward-ip"`
+
+	sections map[string]interface{}
 }
 
-func New(configFile string) (*Configuration, error) {
+// New reads the configuration from configFile, which may be written in JSON,
+// YAML or TOML; see toJSON. Values are taken, in increasing order of
+// precedence, from the defaults, the file, the environment variables starting
+// with EnvPrefix and finally the key=value pairs in overrides. If configFile is
+// empty, the file is skipped. If any value is invalid, the returned error is a
+// *ValidationError listing all problems found.
+func New(configFile string, overrides ...string) (*Configuration, error) {
 	config := &Configuration{
-		ARPBindings:  make(map[string][]string),
-		Interval:     1000000000,
-		SynInterval:  1000,
-		SynThreshold: 1,
-		ForwardIP:    "127.0.0.1",
+		Modules:   []string{"dos", "log"},
+		ForwardIP: "127.0.0.1",
+		sections:  make(map[string]interface{}),
+	}
+	for name, defaults := range sections {
+		config.sections[name] = defaults()
 	}
 
-	file, err := ioutil.ReadFile(configFile)
-	if err != nil {
-		return config, err
+	var ps problems
+	if configFile != "" {
+		file, err := ioutil.ReadFile(configFile)
+		if err != nil {
+			return config, err
+		}
+
+		file, ps = toJSON(configFile, file)
+		if ps == nil {
+			ps = decode(file, root, config.fields())
+		}
 	}
 
-	err = json.Unmarshal(file, &config)
-	if err != nil {
-		return config, err
+	ps = append(ps, config.override(os.Environ(), overrides)...)
+	if err, ok := config.Validate().(*ValidationError); ok {
+		ps = append(ps, err.Problems...)
 	}
+	sortProblems(ps)
+	return config, ps.err()
+}
 
-	return config, nil
+// MarshalJSON writes the configuration, including the sections of all modules,
+// in the format of the configuration file.
+func (c *Configuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.fields())
+}
+
+// Section returns the section of the named module: the value returned by the
+// defaults function it registered, with the values from the file filled in.
+// It returns nil if no section was registered under that name.
+func (c *Configuration) Section(name string) interface{} {
+	return c.sections[name]
+}
+
+// fields returns pointers to all values that can be set from the file, by
+// their key.
+func (c *Configuration) fields() map[string]interface{} {
+	fields := fieldsOf(c)
+	for name, section := range c.sections {
+		fields[name] = section
+	}
+	return fields
 }
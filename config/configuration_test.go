@@ -0,0 +1,233 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	return writeConfigAs(t, "config.json", contents)
+}
+
+func writeConfigAs(t *testing.T, name, contents string) string {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// testSection is a section as a module would declare it.
+type testSection struct {
+	Interval  Duration          `json:"interval"`
+	Threshold int               `json:"threshold"`
+	Bindings  map[string]string `json:"bindings"`
+}
+
+func (s *testSection) Validate() []Problem {
+	var ps []Problem
+	if s.Interval.Duration <= 0 {
+		ps = append(ps, Problem{"interval", "Must be positive"})
+	}
+	for name, value := range s.Bindings {
+		if value == "" {
+			ps = append(ps, Problem{Member("bindings", name), "Empty binding"})
+		}
+	}
+	return ps
+}
+
+func init() {
+	Register("test", func() interface{} {
+		return &testSection{Interval: Duration{time.Second}, Threshold: 1}
+	})
+}
+
+func TestDefaults(t *testing.T) {
+	config, err := New("")
+
+	assert := assert.New(t)
+	assert.Nil(err)
+	assert.Equal([]string{"dos", "log"}, config.Modules)
+	assert.Equal(&testSection{Interval: Duration{time.Second}, Threshold: 1}, config.Section("test"))
+	assert.Nil(config.Validate())
+}
+
+func TestSection(t *testing.T) {
+	path := writeConfig(t, `{"test": {"interval": "500ms"}, "forward-ip": "10.0.0.1"}`)
+	config, err := New(path)
+
+	assert := assert.New(t)
+	assert.Nil(err)
+	assert.Equal("10.0.0.1", config.ForwardIP)
+	assert.Equal(&testSection{Interval: Duration{500 * time.Millisecond}, Threshold: 1}, config.Section("test"))
+	assert.Nil(config.Section("none"))
+}
+
+func TestMissingFile(t *testing.T) {
+	_, err := New("does-not-exist.json")
+	assert.Error(t, err)
+}
+
+func TestAllProblems(t *testing.T) {
+	path := writeConfig(t, `{
+		"modules": "dos",
+		"test": {
+			"interval": "-1s",
+			"threshold": "high",
+			"treshold": 2,
+			"bindings": {"a": "b", "c": ""}
+		},
+		"dos": {},
+		"forward-ip": "::1"
+	}`)
+	_, err := New(path)
+
+	verr, ok := err.(*ValidationError)
+	assert := assert.New(t)
+	assert.True(ok)
+	assert.Equal([]Problem{
+		{"$.dos", "Unknown key"},
+		{"$.forward-ip", "Not an IPv4 address: ::1"},
+		{"$.modules", "Expected an array but found string"},
+		{"$.test.bindings['c']", "Empty binding"},
+		{"$.test.interval", "Must be positive"},
+		{"$.test.threshold", "Expected an integer but found string"},
+		{"$.test.treshold", "Unknown key"},
+	}, verr.Problems)
+}
+
+func TestInvalidDuration(t *testing.T) {
+	path := writeConfig(t, `{"test": {"interval": 1000}}`)
+	_, err := New(path)
+
+	verr, ok := err.(*ValidationError)
+	assert := assert.New(t)
+	assert.True(ok)
+	assert.Equal([]Problem{
+		{"$.test.interval", "Expected a duration such as \"1s\" but found 1000"},
+	}, verr.Problems)
+}
+
+func TestSyntaxError(t *testing.T) {
+	path := writeConfig(t, "{\n\t\"interval\": 1,\n\t\"syn-interval\" 2\n}")
+	_, err := New(path)
+
+	verr, ok := err.(*ValidationError)
+	assert := assert.New(t)
+	assert.True(ok)
+	assert.Equal(1, len(verr.Problems))
+	assert.Equal("$: Invalid JSON at line 3, column 17: invalid character '2' after object key",
+		verr.Problems[0].String())
+}
+
+func TestYAML(t *testing.T) {
+	path := writeConfigAs(t, "config.yaml", `
+# The gateway.
+forward-ip: 10.0.0.1
+modules: [arp, log]
+test:
+  interval: 2s
+  bindings:
+    a: b # Documented, at last.
+`)
+	config, err := New(path)
+
+	assert := assert.New(t)
+	assert.Nil(err)
+	assert.Equal("10.0.0.1", config.ForwardIP)
+	assert.Equal([]string{"arp", "log"}, config.Modules)
+	assert.Equal(&testSection{Interval: Duration{2 * time.Second}, Threshold: 1, Bindings: map[string]string{"a": "b"}},
+		config.Section("test"))
+}
+
+func TestTOML(t *testing.T) {
+	path := writeConfigAs(t, "config.toml", `
+forward-ip = "10.0.0.1"
+
+[test]
+threshold = 3
+interval = "2s"
+`)
+	config, err := New(path)
+
+	assert := assert.New(t)
+	assert.Nil(err)
+	assert.Equal("10.0.0.1", config.ForwardIP)
+	assert.Equal(&testSection{Interval: Duration{2 * time.Second}, Threshold: 3}, config.Section("test"))
+}
+
+func TestOverridePrecedence(t *testing.T) {
+	os.Setenv("ET_TEST_THRESHOLD", "5")
+	os.Setenv("ET_TEST_INTERVAL", "3s")
+	os.Setenv("ET_MODULES", "arp, log")
+	defer os.Unsetenv("ET_TEST_THRESHOLD")
+	defer os.Unsetenv("ET_TEST_INTERVAL")
+	defer os.Unsetenv("ET_MODULES")
+
+	path := writeConfig(t, `{"test": {"threshold": 2, "interval": "2s"}, "forward-ip": "10.0.0.1"}`)
+	config, err := New(path, "test.threshold=7", "forward-ip=10.0.0.2")
+
+	assert := assert.New(t)
+	assert.Nil(err)
+	assert.Equal("10.0.0.2", config.ForwardIP)
+	assert.Equal([]string{"arp", "log"}, config.Modules)
+	assert.Equal(&testSection{Interval: Duration{3 * time.Second}, Threshold: 7}, config.Section("test"))
+}
+
+func TestOverrideReplacesMap(t *testing.T) {
+	path := writeConfig(t, `{"test": {"bindings": {"a": "1", "b": "2"}}}`)
+	config, err := New(path, `test.bindings={"b": "3"}`)
+
+	assert := assert.New(t)
+	assert.Nil(err)
+	assert.Equal(map[string]string{"b": "3"}, config.Section("test").(*testSection).Bindings)
+}
+
+func TestOverrideProblems(t *testing.T) {
+	os.Setenv("ET_TEST_TRESHOLD", "5")
+	defer os.Unsetenv("ET_TEST_TRESHOLD")
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	_, err := New("", "test.threshold=high", "test.bindings={\"a\": \"\"}", "nope=1")
+
+	verr, ok := err.(*ValidationError)
+	assert := assert.New(t)
+	assert.True(ok)
+	assert.Equal([]Problem{
+		{"$.test.bindings['a']", "Empty binding"},
+		{"--set nope", "Unknown key"},
+		{"--set test.threshold", "Expected an integer but found string"},
+	}, verr.Problems)
+	assert.Contains(logged.String(), "Ignoring unknown environment variable ET_TEST_TRESHOLD")
+}
+
+func TestMarshal(t *testing.T) {
+	config, err := New("", "test.interval=1m30s")
+	assert := assert.New(t)
+	assert.Nil(err)
+
+	out, err := json.Marshal(config)
+	assert.Nil(err)
+	path := writeConfig(t, string(out))
+	again, err := New(path)
+	assert.Nil(err)
+	assert.Equal(config, again)
+}
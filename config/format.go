@@ -0,0 +1,70 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// toJSON converts the contents of a configuration file to JSON, picking the
+// format by the file's extension: .yaml and .yml files are read as YAML, .toml
+// files as TOML and all others as JSON. The other formats are converted so
+// that they are decoded and validated exactly like JSON; a problem is returned
+// if the file cannot be parsed.
+func toJSON(path string, data []byte) ([]byte, problems) {
+	var ps problems
+	var format string
+	var value interface{}
+	var err error
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		format = "YAML"
+		err = yaml.Unmarshal(data, &value)
+		value = normalize(value)
+	case ".toml":
+		format = "TOML"
+		var table map[string]interface{}
+		err = toml.Unmarshal(data, &table)
+		value = table
+	default:
+		return data, nil
+	}
+	if err != nil {
+		ps.add(root, "Invalid %s: %v", format, err)
+		return nil, ps
+	}
+
+	// An empty file is an empty configuration, not a missing object.
+	if value == nil {
+		value = map[string]interface{}{}
+	}
+	data, err = json.Marshal(value)
+	if err != nil {
+		ps.add(root, "Cannot convert %s: %v", format, err)
+		return nil, ps
+	}
+	return data, nil
+}
+
+// normalize replaces the map[interface{}]interface{} values produced by the
+// YAML parser, which encoding/json cannot handle, by maps keyed by strings.
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			m[fmt.Sprint(key)] = normalize(elem)
+		}
+		return m
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = normalize(elem)
+		}
+	}
+	return value
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+)
+
+// EnvPrefix is the prefix of the environment variables that override values
+// from the configuration file. The rest of the name is the dotted path of the
+// value in upper case, with dots and dashes replaced by underscores: for
+// example, ET_DOS_SYN_THRESHOLD overrides syn-threshold in the dos section.
+const EnvPrefix = "ET_"
+
+// Overrides holds values given on the command line as key=value, where the key
+// is the dotted path of the value, such as dos.syn-threshold=10. It implements
+// flag.Value, so the flag may be repeated.
+type Overrides []string
+
+func (o *Overrides) String() string {
+	return strings.Join(*o, " ")
+}
+
+func (o *Overrides) Set(s string) error {
+	if !strings.Contains(s, "=") {
+		return fmt.Errorf("Expected key=value but found %s", s)
+	}
+	*o = append(*o, s)
+	return nil
+}
+
+// override sets the values named by the environment variables in environ, and
+// then those in overrides, so that the latter take precedence. Problems are
+// reported by the name of the variable or the key of the override. Unknown
+// environment variables are only logged, since the environment may hold
+// variables with the same prefix meant for other programs.
+func (c *Configuration) override(environ []string, overrides []string) problems {
+	var ps problems
+
+	leaves := c.leaves()
+	keys := make(map[string]string, len(leaves))
+	for key := range leaves {
+		keys[envName(key)] = key
+	}
+
+	for _, variable := range environ {
+		name, value := split(variable)
+		if !strings.HasPrefix(name, EnvPrefix) {
+			continue
+		}
+		key, ok := keys[name]
+		if !ok {
+			log.Println("Ignoring unknown environment variable", name)
+			continue
+		}
+		if err := set(leaves[key], value); err != nil {
+			ps.add(name, "%v", err)
+		}
+	}
+
+	for _, o := range overrides {
+		key, value := split(o)
+		field, ok := leaves[key]
+		if !ok {
+			ps.add("--set "+key, "Unknown key")
+			continue
+		}
+		if err := set(field, value); err != nil {
+			ps.add("--set "+key, "%v", err)
+		}
+	}
+	return ps
+}
+
+// leaves returns pointers to all values that can be overridden, by their
+// dotted path. These are the settings shared by the whole IPS and the values
+// in the sections; objects such as the ARP bindings are set as a whole.
+func (c *Configuration) leaves() map[string]interface{} {
+	leaves := make(map[string]interface{})
+	for name, field := range c.fields() {
+		if _, ok := field.(json.Unmarshaler); !ok && isStruct(field) {
+			for key, value := range fieldsOf(field) {
+				leaves[name+"."+key] = value
+			}
+		} else {
+			leaves[name] = field
+		}
+	}
+	return leaves
+}
+
+// envName returns the name of the environment variable overriding the value at
+// the dotted path key.
+func envName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+}
+
+func split(s string) (string, string) {
+	i := strings.Index(s, "=")
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], s[i+1:]
+}
+
+// set decodes value into field. The value is JSON, but strings need not be
+// quoted and lists of strings may be written separated by commas, so that
+// ET_MODULES=arp,log and ET_WIFI_INTERVAL=2s work as expected. A map is
+// replaced rather than merged with its current entries.
+func set(field interface{}, value string) error {
+	if v := reflect.ValueOf(field).Elem(); v.Kind() == reflect.Map {
+		v.Set(reflect.Zero(v.Type()))
+	}
+
+	if list, ok := field.(*[]string); ok && !strings.HasPrefix(strings.TrimSpace(value), "[") {
+		*list = nil
+		for _, s := range strings.Split(value, ",") {
+			*list = append(*list, strings.TrimSpace(s))
+		}
+		return nil
+	}
+
+	if err := json.Unmarshal([]byte(value), field); err == nil {
+		return nil
+	}
+	quoted, _ := json.Marshal(value)
+	if err := json.Unmarshal(quoted, field); err != nil {
+		return errors.New(typeError(err))
+	}
+	return nil
+}
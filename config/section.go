@@ -0,0 +1,83 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The registered sections, by module name, with a function returning their
+// defaults. Modules register their section from an init function, so it is
+// not modified afterwards.
+var sections = make(map[string]func() interface{})
+
+// Register declares the configuration section of a module. The defaults
+// function must return a pointer to a new struct holding the default values;
+// its fields are set from the file using their json tags, and unknown keys are
+// reported as problems. If the struct implements Validator, it is validated
+// after decoding. Register panics if a section is registered twice.
+func Register(name string, defaults func() interface{}) {
+	if _, ok := sections[name]; ok {
+		panic("config: Register called twice for section " + name)
+	}
+	sections[name] = defaults
+}
+
+// A Validator is a section that can check its own values. The paths of the
+// problems it returns are relative to the section, e.g. "interval" or
+// "bindings['192.168.0.1'][0]"; see Key, Index and Member.
+type Validator interface {
+	Validate() []Problem
+}
+
+// Key returns the path of the named key in the object at path.
+func Key(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// Index returns the path of the i'th element in the array at path.
+func Index(path string, i int) string {
+	return fmt.Sprintf("%s[%d]", path, i)
+}
+
+// Member returns the path of the named member of the object at path, for
+// names that are not known beforehand such as IP addresses.
+func Member(path, name string) string {
+	return fmt.Sprintf("%s['%s']", path, name)
+}
+
+// join prefixes a relative path with the path of its section.
+func join(prefix, path string) string {
+	if strings.HasPrefix(path, "[") {
+		return prefix + path
+	}
+	return Key(prefix, path)
+}
+
+// A Duration is a time.Duration that is written as a string in the file, such
+// as "1s", "500ms" or "1m30s"; see time.ParseDuration.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("Expected a duration such as \"1s\" but found %s", data)
+	}
+
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("Invalid duration: %s", s)
+	}
+	d.Duration = duration
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
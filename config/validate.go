@@ -0,0 +1,191 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// A Problem is something wrong with a single value in the configuration.
+type Problem struct {
+	Path    string // JSON path of the value, e.g. $.arp.bindings['192.168.0.1'][0].
+	Message string
+}
+
+func (p Problem) String() string {
+	return p.Path + ": " + p.Message
+}
+
+// A ValidationError lists all problems found in a configuration.
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Invalid configuration, %d problem(s) found:", len(e.Problems))
+	for _, p := range e.Problems {
+		b.WriteString("\n\t")
+		b.WriteString(p.String())
+	}
+	return b.String()
+}
+
+// problems collects the problems found while validating.
+type problems []Problem
+
+func (ps *problems) add(path, format string, args ...interface{}) {
+	*ps = append(*ps, Problem{path, fmt.Sprintf(format, args...)})
+}
+
+// err returns the problems as a ValidationError, or nil if there are none.
+func (ps problems) err() error {
+	if len(ps) == 0 {
+		return nil
+	}
+	return &ValidationError{ps}
+}
+
+// sortProblems sorts the problems by path, so they are reported in a stable
+// order.
+func sortProblems(ps problems) {
+	sort.SliceStable(ps, func(i, j int) bool {
+		return ps[i].Path < ps[j].Path
+	})
+}
+
+const root = "$"
+
+// decode unmarshals the JSON object in data into fields, which holds pointers
+// to the values of the known keys. Every key is decoded on its own so that all
+// type errors and unknown keys are reported rather than just the first.
+// Structs are decoded recursively in the same way, unless they implement
+// json.Unmarshaler.
+func decode(data []byte, path string, fields map[string]interface{}) problems {
+	var ps problems
+
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		if serr, ok := err.(*json.SyntaxError); ok {
+			line, col := position(data, serr.Offset)
+			ps.add(path, "Invalid JSON at line %d, column %d: %v", line, col, err)
+		} else {
+			ps.add(path, "Expected an object")
+		}
+		return ps
+	}
+
+	for name, raw := range object {
+		field, ok := fields[name]
+		if !ok {
+			ps.add(Key(path, name), "Unknown key")
+			continue
+		}
+
+		if _, ok := field.(json.Unmarshaler); !ok && isStruct(field) {
+			ps = append(ps, decode(raw, Key(path, name), fieldsOf(field))...)
+		} else if err := json.Unmarshal(raw, field); err != nil {
+			ps.add(Key(path, name), "%s", typeError(err))
+		}
+	}
+	return ps
+}
+
+func isStruct(v interface{}) bool {
+	t := reflect.TypeOf(v)
+	return t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct
+}
+
+// fieldsOf returns pointers to the fields of the struct v points to, by the
+// name in their json tag. Fields without a tag are skipped.
+func fieldsOf(v interface{}) map[string]interface{} {
+	fields := make(map[string]interface{})
+	value := reflect.ValueOf(v).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		name := strings.Split(value.Type().Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields[name] = value.Field(i).Addr().Interface()
+		}
+	}
+	return fields
+}
+
+// typeError turns an error from encoding/json into a message that does not
+// repeat the Go types.
+func typeError(err error) string {
+	if terr, ok := err.(*json.UnmarshalTypeError); ok {
+		path := ""
+		if terr.Field != "" {
+			path = " at " + terr.Field
+		}
+		return fmt.Sprintf("Expected %s but found %s%s", jsonType(terr.Type), terr.Value, path)
+	}
+	return err.Error()
+}
+
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+// position converts the offset of a json.SyntaxError, which points just past
+// the offending byte, into its line and column, both counting from one.
+func position(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	if offset > 0 {
+		offset--
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := len(before) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
+
+// Validate checks every value in the configuration, including those in the
+// sections of the modules, and returns a ValidationError listing all problems,
+// or nil if there are none.
+func (c *Configuration) Validate() error {
+	var ps problems
+
+	for i, name := range c.Modules {
+		if name == "" {
+			ps.add(Index(Key(root, "modules"), i), "Empty module name")
+		}
+	}
+
+	if ip := net.ParseIP(c.ForwardIP); ip == nil {
+		ps.add(Key(root, "forward-ip"), "Invalid IP address: %s", c.ForwardIP)
+	} else if ip.To4() == nil {
+		ps.add(Key(root, "forward-ip"), "Not an IPv4 address: %s", c.ForwardIP)
+	}
+
+	for name, section := range c.sections {
+		if v, ok := section.(Validator); ok {
+			for _, p := range v.Validate() {
+				ps.add(join(Key(root, name), p.Path), "%s", p.Message)
+			}
+		}
+	}
+
+	sortProblems(ps)
+	return ps.err()
+}
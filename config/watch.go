@@ -0,0 +1,44 @@
+package config
+
+import (
+	"os"
+	"time"
+)
+
+// Watch polls the file at path every interval and sends on the returned
+// channel whenever its size or modification time has changed. Closing done
+// stops watching.
+func Watch(path string, interval time.Duration, done <-chan struct{}) <-chan struct{} {
+	changed := make(chan struct{}, 1)
+	prev, _ := os.Stat(path)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				cur, err := os.Stat(path)
+				if err != nil {
+					// The file may be in the middle of being
+					// replaced; try again on the next tick.
+					continue
+				}
+				if prev == nil || cur.ModTime() != prev.ModTime() || cur.Size() != prev.Size() {
+					// Don't block if the previous change
+					// has not been handled yet.
+					select {
+					case changed <- struct{}{}:
+					default:
+					}
+				}
+				prev = cur
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return changed
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Hjdskes/ET4397IN/config"
+	"github.com/Hjdskes/ET4397IN/control"
+	"github.com/Hjdskes/ET4397IN/hub"
+	"github.com/Hjdskes/ET4397IN/module"
+)
+
+// A running module, together with how it was selected.
+type running struct {
+	module.Module
+	name    string
+	weight  int
+	enabled bool
+}
+
+// The controller struct owns the configuration and the running modules, which
+// are changed by reloads and by the control API.
+type controller struct {
+	h         *hub.Hub
+	path      string   // The configuration file, if any.
+	overrides []string // The overrides given on the command line.
+	// Called with the new configuration after every change.
+	changed func(c *config.Configuration)
+
+	mutex         sync.Mutex
+	configuration *config.Configuration
+	// The overrides given over the control API, which take precedence
+	// over the others and survive reloads.
+	runtime []string
+	modules []*running
+}
+
+var _ control.Controller = (*controller)(nil)
+
+// active returns all running modules, whether they are enabled or not.
+func (c *controller) active() []module.Module {
+	var ms []module.Module
+	for _, r := range c.modules {
+		ms = append(ms, r.Module)
+	}
+	return ms
+}
+
+// reload re-reads the configuration file, see reload.
+func (c *controller) reload() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	previous := c.configuration
+	c.configuration = reload(c.path, append(c.overrides[:len(c.overrides):len(c.overrides)], c.runtime...), previous, c.active())
+	if c.configuration != previous {
+		c.changed(c.configuration)
+	}
+}
+
+func (c *controller) Modules() []control.Module {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	stats := make(map[hub.Subscriber]hub.SubscriberStats)
+	for _, s := range c.h.Stats() {
+		stats[s.Subscriber] = s
+	}
+
+	var ms []control.Module
+	for _, r := range c.modules {
+		m := control.Module{
+			Name:     r.name,
+			Enabled:  r.enabled,
+			Weight:   r.weight,
+			Received: stats[r.Module].Received,
+			Rejected: stats[r.Module].Rejected,
+		}
+		if i, ok := r.Module.(module.Inspector); ok {
+			m.State = i.State()
+		}
+		ms = append(ms, m)
+	}
+	return ms
+}
+
+func (c *controller) SetEnabled(name string, enabled bool) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, r := range c.modules {
+		if r.name != name {
+			continue
+		}
+		if r.enabled == enabled {
+			return nil
+		}
+		if enabled {
+			if err := c.h.SubscribeWeighted(r.Module, r.weight); err != nil {
+				return err
+			}
+		} else {
+			c.h.Unsubscribe(r.Module)
+		}
+		r.enabled = enabled
+		return nil
+	}
+	return fmt.Errorf("%w: %s", control.ErrUnknownModule, name)
+}
+
+func (c *controller) Configuration() *config.Configuration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.configuration
+}
+
+func (c *controller) Configure(overrides ...string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	runtime := c.runtime
+	for _, o := range overrides {
+		runtime = replaceOverride(runtime, o)
+	}
+	next, err := config.New(c.path, append(c.overrides[:len(c.overrides):len(c.overrides)], runtime...)...)
+	if err != nil {
+		return err
+	}
+	if err = module.Reload(c.active(), c.configuration, next); err != nil {
+		return err
+	}
+
+	c.configuration = next
+	c.runtime = runtime
+	c.changed(next)
+	return nil
+}
+
+// replaceOverride returns a copy of overrides with o in place of any override
+// of the same key, or appended if there is none.
+func replaceOverride(overrides []string, o string) []string {
+	key := strings.SplitN(o, "=", 2)[0]
+	var replaced []string
+	for _, existing := range overrides {
+		if strings.SplitN(existing, "=", 2)[0] != key {
+			replaced = append(replaced, existing)
+		}
+	}
+	return append(replaced, o)
+}
@@ -0,0 +1,91 @@
+package control
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Hjdskes/ET4397IN/hub"
+)
+
+// An Alert is an alert raised by a module, as shown by the control API.
+type Alert struct {
+	ID          uint64    `json:"id,omitempty"`
+	Time        time.Time `json:"time"`
+	Severity    string    `json:"severity"`
+	Module      string    `json:"module"`
+	Rule        int       `json:"rule,omitempty"`
+	Interface   string    `json:"interface,omitempty"`
+	Source      string    `json:"source,omitempty"`
+	Destination string    `json:"destination,omitempty"`
+	Message     string    `json:"message"`
+}
+
+var _ hub.AlertHandler = (*Alerts)(nil)
+
+// The Alerts struct remembers the most recent alerts raised by the modules.
+type Alerts struct {
+	now func() time.Time
+
+	mutex  sync.Mutex
+	alerts []Alert // A ring buffer, of which next is the oldest entry once full.
+	next   int
+	full   bool
+}
+
+// NewAlerts returns an Alerts remembering at most size alerts.
+func NewAlerts(size int) *Alerts {
+	if size < 1 {
+		size = 1
+	}
+	return &Alerts{now: time.Now, alerts: make([]Alert, size)}
+}
+
+func (a *Alerts) Topics() []string {
+	return []string{hub.TopicAlert + ".#"}
+}
+
+func (a *Alerts) ReceiveAlert(e *hub.AlertEvent) {
+	alert := Alert{
+		ID:          e.ID,
+		Time:        e.Time,
+		Severity:    e.Severity.String(),
+		Module:      e.Module,
+		Interface:   e.Interface,
+		Source:      e.Source.String(),
+		Destination: e.Destination.String(),
+		Message:     e.Message,
+	}
+	if alert.Time.IsZero() {
+		alert.Time = a.now()
+	}
+	if e.Rule != nil {
+		alert.Rule = e.Rule.ID
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.alerts[a.next] = alert
+	a.next = (a.next + 1) % len(a.alerts)
+	a.full = a.full || a.next == 0
+}
+
+// Recent returns at most n of the most recent alerts, newest first. If n is
+// not positive, all remembered alerts are returned.
+func (a *Alerts) Recent(n int) []Alert {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	count := a.next
+	if a.full {
+		count = len(a.alerts)
+	}
+	if n <= 0 || n > count {
+		n = count
+	}
+
+	recent := make([]Alert, n)
+	for i := range recent {
+		recent[i] = a.alerts[(a.next-1-i+len(a.alerts))%len(a.alerts)]
+	}
+	return recent
+}
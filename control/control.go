@@ -0,0 +1,310 @@
+// This package implements the control API: a REST API speaking JSON, through
+// which the IPS can be inspected and steered while it is running, without
+// losing the state its modules have built up. It is meant to be served on a
+// local socket only, since it is not authenticated.
+//
+// The API consists of the following endpoints:
+//
+//	GET    /modules                  list the modules and their state
+//	GET    /modules/NAME             show a single module
+//	POST   /modules/NAME/enable      let a module receive events again
+//	POST   /modules/NAME/disable     stop delivering events to a module
+//	GET    /config                   show the configuration in effect
+//	PATCH  /config                   change values, e.g. {"dos.syn-threshold": 10}
+//	GET    /arp/bindings             list the valid IP-to-MAC bindings
+//	PUT    /arp/bindings             replace all bindings
+//	PUT    /arp/bindings/IP          set the MAC addresses of an IP address
+//	DELETE /arp/bindings/IP          remove the bindings of an IP address
+//	GET    /dos/connections          list the hosts that completed a handshake
+//	GET    /alerts?limit=N           list the most recent alerts, newest first
+//
+// Errors are reported as {"error": "..."}.
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Hjdskes/ET4397IN/config"
+	"github.com/Hjdskes/ET4397IN/module"
+)
+
+// ErrUnknownModule is returned by a Controller for a module that is not
+// running.
+var ErrUnknownModule = errors.New("Unknown module")
+
+// A Module is a running module as shown by the control API.
+type Module struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	Weight  int    `json:"weight"`
+	// The events delivered to the module and the packets it rejected
+	// since it was last enabled.
+	Received uint64 `json:"received"`
+	Rejected uint64 `json:"rejected"`
+	// The state of modules implementing module.Inspector.
+	State interface{} `json:"state,omitempty"`
+}
+
+// A Controller is the running IPS as seen by the control API.
+type Controller interface {
+	// Modules returns the running modules, in the order in which they
+	// were selected.
+	Modules() []Module
+	// SetEnabled subscribes or unsubscribes a module on the hub. A
+	// disabled module keeps its state.
+	SetEnabled(name string, enabled bool) error
+	// Configuration returns the configuration in effect.
+	Configuration() *config.Configuration
+	// Configure applies overrides as given to --set on top of the
+	// configuration and hands the result to the modules. If it returns
+	// an error, nothing has changed.
+	Configure(overrides ...string) error
+}
+
+// The api struct serves the control API.
+type api struct {
+	c      Controller
+	alerts *Alerts
+	mux    *http.ServeMux
+}
+
+// NewHandler returns a handler serving the control API for c. The recent
+// alerts are taken from alerts, which must be subscribed on the hub.
+func NewHandler(c Controller, alerts *Alerts) http.Handler {
+	a := &api{c: c, alerts: alerts, mux: http.NewServeMux()}
+	a.mux.HandleFunc("/modules", a.modules)
+	a.mux.HandleFunc("/modules/", a.module)
+	a.mux.HandleFunc("/config", a.config)
+	a.mux.HandleFunc("/arp/bindings", a.bindings)
+	a.mux.HandleFunc("/arp/bindings/", a.binding)
+	a.mux.HandleFunc("/dos/connections", a.connections)
+	a.mux.HandleFunc("/alerts", a.recentAlerts)
+	return a.mux
+}
+
+// An httpError is an error with the status code it is reported with.
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func badRequest(err error) error {
+	return &httpError{http.StatusBadRequest, err}
+}
+
+func notFound(err error) error {
+	return &httpError{http.StatusNotFound, err}
+}
+
+// reply writes v as JSON, or the error if err is not nil. Invalid
+// configurations and unknown modules are the client's fault.
+func reply(w http.ResponseWriter, v interface{}, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusInternalServerError
+		var herr *httpError
+		var verr *config.ValidationError
+		switch {
+		case errors.As(err, &herr):
+			status = herr.status
+		case errors.As(err, &verr):
+			status = http.StatusBadRequest
+		case errors.Is(err, ErrUnknownModule):
+			status = http.StatusNotFound
+		}
+		v = map[string]string{"error": err.Error()}
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// allow replies with an error and returns false if the method of r is not one
+// of methods.
+func allow(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	reply(w, nil, &httpError{http.StatusMethodNotAllowed, fmt.Errorf("Method %s not allowed", r.Method)})
+	return false
+}
+
+func decode(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return badRequest(fmt.Errorf("Invalid request body: %v", err))
+	}
+	return nil
+}
+
+func (a *api) modules(w http.ResponseWriter, r *http.Request) {
+	if allow(w, r, http.MethodGet) {
+		reply(w, a.c.Modules(), nil)
+	}
+}
+
+func (a *api) find(name string) (Module, error) {
+	for _, m := range a.c.Modules() {
+		if m.Name == name {
+			return m, nil
+		}
+	}
+	return Module{}, fmt.Errorf("%w: %s", ErrUnknownModule, name)
+}
+
+func (a *api) module(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, "/modules/"), "/")
+	switch {
+	case len(path) == 1:
+		if allow(w, r, http.MethodGet) {
+			m, err := a.find(path[0])
+			reply(w, m, err)
+		}
+	case len(path) == 2 && (path[1] == "enable" || path[1] == "disable"):
+		if !allow(w, r, http.MethodPost) {
+			return
+		}
+		if err := a.c.SetEnabled(path[0], path[1] == "enable"); err != nil {
+			reply(w, nil, err)
+			return
+		}
+		m, err := a.find(path[0])
+		reply(w, m, err)
+	default:
+		reply(w, nil, notFound(fmt.Errorf("Not found: %s", r.URL.Path)))
+	}
+}
+
+func (a *api) config(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet, http.MethodPatch) {
+		return
+	}
+	if r.Method == http.MethodPatch {
+		var values map[string]json.RawMessage
+		if err := decode(r, &values); err != nil {
+			reply(w, nil, err)
+			return
+		}
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var overrides []string
+		for _, key := range keys {
+			overrides = append(overrides, key+"="+string(values[key]))
+		}
+		if err := a.c.Configure(overrides...); err != nil {
+			reply(w, nil, err)
+			return
+		}
+	}
+	reply(w, a.c.Configuration(), nil)
+}
+
+// currentBindings returns a copy of the ARP bindings in effect.
+func (a *api) currentBindings() map[string][]string {
+	bindings := make(map[string][]string)
+	for ip, macs := range a.c.Configuration().Section("arp").(*module.ARPConfig).Bindings {
+		bindings[ip] = macs
+	}
+	return bindings
+}
+
+// setBindings configures the ARP bindings as a whole and replies with them.
+func (a *api) setBindings(w http.ResponseWriter, bindings map[string][]string) {
+	data, err := json.Marshal(bindings)
+	if err == nil {
+		err = a.c.Configure("arp.bindings=" + string(data))
+	}
+	if err != nil {
+		reply(w, nil, err)
+		return
+	}
+	reply(w, a.currentBindings(), nil)
+}
+
+func (a *api) bindings(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet, http.MethodPut) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		reply(w, a.currentBindings(), nil)
+		return
+	}
+
+	var bindings map[string][]string
+	if err := decode(r, &bindings); err != nil {
+		reply(w, nil, err)
+		return
+	}
+	a.setBindings(w, bindings)
+}
+
+func (a *api) binding(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodPut, http.MethodDelete) {
+		return
+	}
+	ip := strings.TrimPrefix(r.URL.Path, "/arp/bindings/")
+	if net.ParseIP(ip).To4() == nil {
+		reply(w, nil, badRequest(fmt.Errorf("Invalid IPv4 address: %s", ip)))
+		return
+	}
+
+	bindings := a.currentBindings()
+	if r.Method == http.MethodDelete {
+		if _, ok := bindings[ip]; !ok {
+			reply(w, nil, notFound(fmt.Errorf("No bindings for %s", ip)))
+			return
+		}
+		delete(bindings, ip)
+	} else {
+		var macs []string
+		if err := decode(r, &macs); err != nil {
+			reply(w, nil, err)
+			return
+		}
+		bindings[ip] = macs
+	}
+	a.setBindings(w, bindings)
+}
+
+func (a *api) connections(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	m, err := a.find("dos")
+	if err != nil {
+		reply(w, nil, err)
+		return
+	}
+	reply(w, m.State.(module.DoSState).Connections, nil)
+}
+
+func (a *api) recentAlerts(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	var limit int
+	if s := r.URL.Query().Get("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 {
+			reply(w, nil, badRequest(fmt.Errorf("Invalid limit: %s", s)))
+			return
+		}
+	}
+	reply(w, a.alerts.Recent(limit), nil)
+}
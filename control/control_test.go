@@ -0,0 +1,167 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Hjdskes/ET4397IN/config"
+	"github.com/Hjdskes/ET4397IN/hub"
+	"github.com/Hjdskes/ET4397IN/module"
+	"github.com/stretchr/testify/assert"
+)
+
+// testController keeps its overrides and builds the configuration from them.
+type testController struct {
+	modules   []Module
+	overrides []string
+	config    *config.Configuration
+}
+
+func newTestController(t *testing.T) *testController {
+	c, err := config.New("", `arp.bindings={"10.0.0.1": ["aa:bb:cc:dd:ee:ff"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testController{
+		modules: []Module{
+			{Name: "arp", Enabled: true, Weight: 1},
+			{Name: "dos", Enabled: true, Weight: 2, State: module.DoSState{Connections: []string{"10.0.0.2"}}},
+		},
+		config: c,
+	}
+}
+
+func (c *testController) Modules() []Module {
+	return c.modules
+}
+
+func (c *testController) SetEnabled(name string, enabled bool) error {
+	for i := range c.modules {
+		if c.modules[i].Name == name {
+			c.modules[i].Enabled = enabled
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownModule, name)
+}
+
+func (c *testController) Configuration() *config.Configuration {
+	return c.config
+}
+
+func (c *testController) Configure(overrides ...string) error {
+	next, err := config.New("", overrides...)
+	if err != nil {
+		return err
+	}
+	c.overrides = overrides
+	c.config = next
+	return nil
+}
+
+func request(t *testing.T, h http.Handler, method, path, body string) (int, string) {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code, strings.TrimSpace(w.Body.String())
+}
+
+func TestModules(t *testing.T) {
+	c := newTestController(t)
+	h := NewHandler(c, NewAlerts(10))
+
+	assert := assert.New(t)
+	code, body := request(t, h, http.MethodGet, "/modules/dos", "")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(`{"name":"dos","enabled":true,"weight":2,"received":0,"rejected":0,"state":{"syn-threshold":0,"syns":0,"connections":["10.0.0.2"]}}`, body)
+
+	code, body = request(t, h, http.MethodPost, "/modules/arp/disable", "")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(false, c.modules[0].Enabled)
+
+	code, body = request(t, h, http.MethodPost, "/modules/nope/enable", "")
+	assert.Equal(http.StatusNotFound, code)
+	assert.Equal(`{"error":"Unknown module: nope"}`, body)
+
+	code, _ = request(t, h, http.MethodGet, "/modules/arp/enable", "")
+	assert.Equal(http.StatusMethodNotAllowed, code)
+
+	code, body = request(t, h, http.MethodGet, "/dos/connections", "")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(`["10.0.0.2"]`, body)
+}
+
+func TestConfig(t *testing.T) {
+	c := newTestController(t)
+	h := NewHandler(c, NewAlerts(10))
+
+	assert := assert.New(t)
+	code, _ := request(t, h, http.MethodPatch, "/config", `{"dos.syn-threshold": 10, "wifi.interval": "2s"}`)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal([]string{"dos.syn-threshold=10", `wifi.interval="2s"`}, c.overrides)
+
+	code, body := request(t, h, http.MethodPatch, "/config", `{"dos.syn-threshold": 0}`)
+	assert.Equal(http.StatusBadRequest, code)
+	assert.Contains(body, "syn-threshold")
+
+	code, _ = request(t, h, http.MethodPatch, "/config", `not json`)
+	assert.Equal(http.StatusBadRequest, code)
+}
+
+func TestBindings(t *testing.T) {
+	c := newTestController(t)
+	h := NewHandler(c, NewAlerts(10))
+
+	assert := assert.New(t)
+	code, body := request(t, h, http.MethodGet, "/arp/bindings", "")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(`{"10.0.0.1":["aa:bb:cc:dd:ee:ff"]}`, body)
+
+	// Bindings are set as a whole, so the existing ones must be kept.
+	code, body = request(t, h, http.MethodPut, "/arp/bindings/10.0.0.2", `["11:22:33:44:55:66"]`)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(`{"10.0.0.1":["aa:bb:cc:dd:ee:ff"],"10.0.0.2":["11:22:33:44:55:66"]}`, body)
+
+	code, body = request(t, h, http.MethodDelete, "/arp/bindings/10.0.0.1", "")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(`{"10.0.0.2":["11:22:33:44:55:66"]}`, body)
+
+	code, _ = request(t, h, http.MethodDelete, "/arp/bindings/10.0.0.1", "")
+	assert.Equal(http.StatusNotFound, code)
+
+	code, _ = request(t, h, http.MethodPut, "/arp/bindings/10.0.0.3", `["not a mac"]`)
+	assert.Equal(http.StatusBadRequest, code)
+
+	code, _ = request(t, h, http.MethodPut, "/arp/bindings/nope", `["11:22:33:44:55:66"]`)
+	assert.Equal(http.StatusBadRequest, code)
+}
+
+func TestAlerts(t *testing.T) {
+	alerts := NewAlerts(2)
+	alerts.now = func() time.Time { return time.Unix(0, 0).UTC() }
+	for _, msg := range []string{"first", "second", "third"} {
+		alerts.ReceiveAlert(&hub.AlertEvent{Severity: hub.Notice, Module: "arp", Message: msg})
+	}
+
+	assert := assert.New(t)
+	recent := alerts.Recent(0)
+	assert.Equal(2, len(recent))
+	assert.Equal("third", recent[0].Message)
+	assert.Equal("second", recent[1].Message)
+	assert.Equal(1, len(alerts.Recent(1)))
+
+	h := NewHandler(newTestController(t), alerts)
+	code, body := request(t, h, http.MethodGet, "/alerts?limit=1", "")
+	assert.Equal(http.StatusOK, code)
+	var decoded []Alert
+	assert.Nil(json.Unmarshal([]byte(body), &decoded))
+	assert.Equal([]Alert{{Time: time.Unix(0, 0).UTC(), Severity: "notice", Module: "arp", Message: "third"}}, decoded)
+
+	code, _ = request(t, h, http.MethodGet, "/alerts?limit=zero", "")
+	assert.Equal(http.StatusBadRequest, code)
+}
@@ -0,0 +1,103 @@
+package hub
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// A Rule describes a condition that a module detects, and is the template of
+// the alerts it raises for it.
+type Rule struct {
+	ID       int    // Unique identifier, reported as the signature id.
+	Module   string // Name of the module detecting the condition.
+	Name     string // Short name, unique within the module.
+	Severity Severity
+	Category string // Class of the condition, e.g. "Attempted Denial of Service".
+	Template string // Format of the message, see fmt.Sprintf.
+}
+
+// An Endpoint is the sender or receiver of the packet that triggered an
+// alert. Addresses that are not known are left empty.
+type Endpoint struct {
+	MAC  string
+	IP   string
+	Port uint16
+}
+
+// String returns the most specific address of the Endpoint: the IP address
+// and port, the IP address or the MAC address.
+func (e Endpoint) String() string {
+	switch {
+	case e.IP != "" && e.Port != 0:
+		return net.JoinHostPort(e.IP, strconv.Itoa(int(e.Port)))
+	case e.IP != "":
+		return e.IP
+	default:
+		return e.MAC
+	}
+}
+
+// The identifier of the last alert created by NewAlert.
+var lastAlertID uint64
+
+// NewAlert returns an alert for a rule, of which the message is the template
+// formatted with args. If e is not nil, the alert refers to its packet: the
+// time, interface and addresses are taken from it, and a module may fill in
+// any addresses its own decoding knows better, and once the alert is
+// published it is listed in the Alerts of e. Every alert is given an
+// identifier that is unique while the IPS is running.
+func NewAlert(rule *Rule, e *PacketEvent, args ...interface{}) *AlertEvent {
+	a := &AlertEvent{
+		ID:       atomic.AddUint64(&lastAlertID, 1),
+		Severity: rule.Severity,
+		Module:   rule.Module,
+		Rule:     rule,
+		Message:  fmt.Sprintf(rule.Template, args...),
+	}
+	if e != nil {
+		a.Interface = e.Interface
+		a.Packet = e.Packet
+		a.origin = e
+		a.Time = e.Packet.Metadata().Timestamp
+		addresses(a, e.Packet)
+	}
+	if a.Time.IsZero() {
+		a.Time = time.Now()
+	}
+	return a
+}
+
+// addresses fills in the addresses of the alert from the link, network and
+// transport layers of a packet.
+func addresses(a *AlertEvent, p gopacket.Packet) {
+	if l := p.LinkLayer(); l != nil {
+		if src, dst := l.LinkFlow().Endpoints(); src.EndpointType() == layers.EndpointMAC {
+			a.Source.MAC, a.Destination.MAC = src.String(), dst.String()
+		}
+	}
+	if n := p.NetworkLayer(); n != nil {
+		src, dst := n.NetworkFlow().Endpoints()
+		a.Source.IP, a.Destination.IP = src.String(), dst.String()
+	}
+
+	switch t := p.TransportLayer().(type) {
+	case *layers.TCP:
+		a.Protocol = "TCP"
+		a.Source.Port, a.Destination.Port = uint16(t.SrcPort), uint16(t.DstPort)
+	case *layers.UDP:
+		a.Protocol = "UDP"
+		a.Source.Port, a.Destination.Port = uint16(t.SrcPort), uint16(t.DstPort)
+	default:
+		if p.Layer(layers.LayerTypeICMPv4) != nil {
+			a.Protocol = "ICMP"
+		} else if p.Layer(layers.LayerTypeICMPv6) != nil {
+			a.Protocol = "IPv6-ICMP"
+		}
+	}
+}
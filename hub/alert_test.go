@@ -0,0 +1,96 @@
+package hub
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+)
+
+func testPacket(t *testing.T) *PacketEvent {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		DstMAC:       net.HardwareAddr{0x11, 0x22, 0x33, 0x44, 0x55, 0x66},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+	tcp := &layers.TCP{SrcPort: 1234, DstPort: 80, SYN: true}
+	tcp.SetNetworkLayerForChecksum(ip)
+
+	buffer := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buffer, opts, eth, ip, tcp); err != nil {
+		t.Fatal(err)
+	}
+	packet := gopacket.NewPacket(buffer.Bytes(), layers.LinkTypeEthernet, gopacket.Default)
+	packet.Metadata().Timestamp = time.Date(2016, 11, 24, 21, 27, 9, 0, time.UTC)
+	return &PacketEvent{Packet: packet, Interface: "eth0"}
+}
+
+func TestNewAlert(t *testing.T) {
+	rule := &Rule{ID: 1, Module: "test", Name: "syn", Severity: Warning, Template: "Host %v sent a SYN"}
+	e := testPacket(t)
+	alert := NewAlert(rule, e, "10.0.0.1")
+
+	assert := assert.New(t)
+	assert.Equal("Host 10.0.0.1 sent a SYN", alert.Message)
+	assert.Equal(Warning, alert.Severity)
+	assert.Equal("test", alert.Module)
+	assert.Equal("alert.test.warning", alert.Topic())
+	assert.Equal(e.Packet.Metadata().Timestamp, alert.Time)
+	assert.Equal("eth0", alert.Interface)
+	assert.Equal("TCP", alert.Protocol)
+	assert.Equal(Endpoint{MAC: "aa:bb:cc:dd:ee:ff", IP: "10.0.0.1", Port: 1234}, alert.Source)
+	assert.Equal(Endpoint{MAC: "11:22:33:44:55:66", IP: "10.0.0.2", Port: 80}, alert.Destination)
+	assert.Equal("10.0.0.1:1234", alert.Source.String())
+
+	other := NewAlert(rule, nil, "10.0.0.1")
+	assert.NotEqual(alert.ID, other.ID)
+	assert.False(other.Time.IsZero())
+	assert.Equal(Endpoint{}, other.Source)
+}
+
+type verdictSubscriber struct {
+	verdicts []*VerdictEvent
+}
+
+func (s *verdictSubscriber) Topics() []string {
+	return []string{"verdict"}
+}
+
+func (s *verdictSubscriber) ReceiveVerdict(e *VerdictEvent) {
+	s.verdicts = append(s.verdicts, e)
+}
+
+func TestPacketAlerts(t *testing.T) {
+	rule := &Rule{ID: 1, Module: "test", Name: "syn", Severity: Warning, Template: "SYN"}
+	e := testPacket(t)
+	h := NewHub()
+	s := &verdictSubscriber{}
+	assert.Nil(t, h.Subscribe(s))
+
+	assert := assert.New(t)
+	// Only the alerts that are published are listed.
+	NewAlert(rule, e)
+	published := NewAlert(rule, e)
+	h.Publish(published)
+	h.Publish(NewAlert(rule, nil))
+	assert.Equal([]*AlertEvent{published}, e.Alerts())
+
+	h.Publish(&VerdictEvent{Packet: e, Accept: false})
+	assert.Equal([]*VerdictEvent{{Packet: e, Accept: false}}, s.verdicts)
+}
+
+func TestParseSeverity(t *testing.T) {
+	assert := assert.New(t)
+	for _, s := range []Severity{Notice, Warning, Error} {
+		parsed, err := ParseSeverity(s.String())
+		assert.Nil(err)
+		assert.Equal(s, parsed)
+	}
+	_, err := ParseSeverity("critical")
+	assert.NotNil(err)
+}
@@ -0,0 +1,276 @@
+package hub
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// An Event is a typed message that is sent over the hub. Only the event types
+// declared in this package implement it, so every payload that is published
+// has a known shape and a known handler, see Subscribe.
+type Event interface {
+	// Topic returns the topic under which the event is published.
+	Topic() string
+
+	// event is unexported to prevent other packages from declaring events
+	// that no subscriber knows how to handle.
+	event()
+}
+
+// Root topics of the event types.
+const (
+	TopicPacket  = "packet"
+	TopicVerdict = "verdict"
+	TopicAlert   = "alert"
+	TopicMetric  = "metric"
+	TopicControl = "control"
+)
+
+// A PacketEvent carries a single captured packet. Its topic is "packet".
+type PacketEvent struct {
+	Packet gopacket.Packet
+	// Interface is the name of the device the packet was captured on, or
+	// the path of the file it was read from.
+	Interface string
+	// LinkType is the type of the first layer of the packet.
+	LinkType layers.LinkType
+
+	// The alerts published for the packet, see Alerts.
+	mutex  sync.Mutex
+	alerts []*AlertEvent
+}
+
+func (e *PacketEvent) Topic() string {
+	return TopicPacket
+}
+
+func (e *PacketEvent) event() {}
+
+// Alerts returns the alerts created for the packet by NewAlert that have been
+// published so far, in the order in which they were published.
+func (e *PacketEvent) Alerts() []*AlertEvent {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return append([]*AlertEvent(nil), e.alerts...)
+}
+
+func (e *PacketEvent) addAlert(a *AlertEvent) {
+	e.mutex.Lock()
+	e.alerts = append(e.alerts, a)
+	e.mutex.Unlock()
+}
+
+// A VerdictEvent is published once the subscribers of a PacketEvent have
+// passed their verdict on it, so that e.g. the alerts the packet raised can be
+// saved along with it. Its topic is "verdict".
+type VerdictEvent struct {
+	Packet *PacketEvent
+	// Whether the packet was accepted.
+	Accept bool
+}
+
+func (e *VerdictEvent) Topic() string {
+	return TopicVerdict
+}
+
+func (e *VerdictEvent) event() {}
+
+// Severity indicates how serious an alert is.
+type Severity int
+
+// Severity values.
+const (
+	Notice Severity = iota
+	Warning
+	Error
+)
+
+// String returns a string representation of the Severity.
+func (s Severity) String() string {
+	switch s {
+	case Notice:
+		return "notice"
+	case Warning:
+		return "warning"
+	case Error:
+		return "error"
+	default:
+		return "N/A"
+	}
+}
+
+// ParseSeverity returns the Severity of which s is the string representation.
+func ParseSeverity(s string) (Severity, error) {
+	for _, severity := range []Severity{Notice, Warning, Error} {
+		if severity.String() == s {
+			return severity, nil
+		}
+	}
+	return Notice, fmt.Errorf("Invalid severity %q, must be one of notice, warning, error", s)
+}
+
+// An AlertEvent is raised by a module when it detects an erroneous or
+// noticable condition. Its topic is "alert.<module>.<severity>", so that e.g.
+// "alert.arp.#" selects all alerts from the ARP module and "alert.*.error"
+// selects all errors.
+//
+// Alerts are usually created from a Rule with NewAlert, which fills in the
+// fields describing the packet that triggered it.
+type AlertEvent struct {
+	ID       uint64    // Identifier of the alert, see NewAlert.
+	Time     time.Time // Time at which the triggering packet was captured.
+	Severity Severity
+	Module   string // Name of the module raising the alert.
+	Rule     *Rule  // The rule the alert was raised for, if any.
+	Message  string // Human readable description of the condition.
+
+	Source      Endpoint // The offending host, as far as known.
+	Destination Endpoint // The targeted host, as far as known.
+	Protocol    string   // Transport protocol of the packet, e.g. "TCP".
+	Interface   string   // See PacketEvent.
+	// The packet that triggered the alert, if any, and whether the module
+	// rejected it.
+	Packet  gopacket.Packet
+	Blocked bool
+
+	// The event of the packet, to which the alert is added when it is
+	// published.
+	origin *PacketEvent
+}
+
+func (e *AlertEvent) Topic() string {
+	return TopicAlert + separator + e.Module + separator + e.Severity.String()
+}
+
+func (e *AlertEvent) event() {}
+
+// MetricKind tells how the value of a MetricEvent is to be interpreted.
+type MetricKind int
+
+// MetricKind values.
+const (
+	Gauge   MetricKind = iota // The value is the current measurement.
+	Counter                   // The value is added to a running total.
+)
+
+// A MetricEvent reports a measurement made by a module. Its topic is
+// "metric.<module>.<name>".
+type MetricEvent struct {
+	Module string
+	Name   string
+	Kind   MetricKind
+	Value  float64
+	// Labels tell apart the measurements of the same name, as pairs of
+	// label names and values, e.g. "type", "spurious-reply".
+	Labels []string
+}
+
+func (e *MetricEvent) Topic() string {
+	return TopicMetric + separator + e.Module + separator + e.Name
+}
+
+func (e *MetricEvent) event() {}
+
+// A ControlEvent instructs subscribers to perform a command. Its topic is
+// "control.<command>".
+type ControlEvent struct {
+	Command string
+	Args    []string
+}
+
+func (e *ControlEvent) Topic() string {
+	return TopicControl + separator + e.Command
+}
+
+func (e *ControlEvent) event() {}
+
+// A PacketHandler receives PacketEvents. It passes its verdict in the return
+// value, where true means the packet is safe and false means it's not.
+type PacketHandler interface {
+	ReceivePacket(e *PacketEvent) bool
+}
+
+// A VerdictHandler receives VerdictEvents.
+type VerdictHandler interface {
+	ReceiveVerdict(e *VerdictEvent)
+}
+
+// An AlertHandler receives AlertEvents.
+type AlertHandler interface {
+	ReceiveAlert(e *AlertEvent)
+}
+
+// A MetricHandler receives MetricEvents.
+type MetricHandler interface {
+	ReceiveMetric(e *MetricEvent)
+}
+
+// A ControlHandler receives ControlEvents.
+type ControlHandler interface {
+	ReceiveControl(e *ControlEvent)
+}
+
+// deliver passes an event to the handler of a subscriber. Subscribers that
+// cannot handle the event, which is only possible when they subscribed using
+// a wildcard at the root level, accept it.
+func deliver(s Subscriber, e Event) bool {
+	switch e := e.(type) {
+	case *PacketEvent:
+		if h, ok := s.(PacketHandler); ok {
+			return h.ReceivePacket(e)
+		}
+	case *VerdictEvent:
+		if h, ok := s.(VerdictHandler); ok {
+			h.ReceiveVerdict(e)
+		}
+	case *AlertEvent:
+		if h, ok := s.(AlertHandler); ok {
+			h.ReceiveAlert(e)
+		}
+	case *MetricEvent:
+		if h, ok := s.(MetricHandler); ok {
+			h.ReceiveMetric(e)
+		}
+	case *ControlEvent:
+		if h, ok := s.(ControlHandler); ok {
+			h.ReceiveControl(e)
+		}
+	}
+	return true
+}
+
+// checkHandler returns an error if a subscriber does not implement the
+// handler for the events published under the root of pattern.
+func checkHandler(s Subscriber, pattern string) error {
+	var ok bool
+	switch root := root(pattern); root {
+	case TopicPacket:
+		_, ok = s.(PacketHandler)
+	case TopicVerdict:
+		_, ok = s.(VerdictHandler)
+	case TopicAlert:
+		_, ok = s.(AlertHandler)
+	case TopicMetric:
+		_, ok = s.(MetricHandler)
+	case TopicControl:
+		_, ok = s.(ControlHandler)
+	case wildOne, wildMany:
+		_, packet := s.(PacketHandler)
+		_, verdict := s.(VerdictHandler)
+		_, alert := s.(AlertHandler)
+		_, metric := s.(MetricHandler)
+		_, control := s.(ControlHandler)
+		ok = packet || verdict || alert || metric || control
+	default:
+		return fmt.Errorf("Unknown topic: %s", pattern)
+	}
+
+	if !ok {
+		return fmt.Errorf("%T subscribes to %s but cannot handle its events", s, pattern)
+	}
+	return nil
+}
@@ -2,69 +2,304 @@
 // https://github.com/vtg/pubsub.
 package hub
 
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Hjdskes/ET4397IN/stats"
+)
+
 // Every type wanting to subscribe on the message bus should implement the Subscriber interface.
-// It dictates that the subscriber is able to declare the topics they want to receive messages for,
-// and that they can receive arbitrary arguments over the message bus.
+// It dictates that the subscriber is able to declare the topics they want to receive messages for.
+// For every kind of Event under those topics, the subscriber must implement the matching
+// handler interface, e.g. PacketHandler for "packet"; this is checked by Subscribe.
 //
-// The subscriber itself is responsible for converting the arguments to the correct type.
+// Subscribers are identified by their value when unsubscribing, so a type
+// implementing Subscriber must be comparable; pointers are recommended.
+// Subscribe refuses subscribers that cannot be compared, such as structs
+// holding a slice, which makes it safe to use the Subscribers of the hub as
+// map keys.
 type Subscriber interface {
 	// Topics returns an array of topics that the Subscriber subcribes to.
+	// The topics may contain wildcards, see Match.
 	Topics() []string
+}
+
+type subscription struct {
+	subscriber Subscriber
+	topics     []string
+	weight     int // Weight of this subscriber's verdict under the Weighted policy.
+	counters   *counters
+}
 
-	// Receive is called when there is a message under a certain topic to which the
-	// subscriber has subscribed. The message's contents are passed as arguments; the subscriber
-	// is responsible for converting them to the proper format.
-	//
-	// The subscriber passes its verdict in the return value, where true
-	// means the package is safe and false means it's not.
-	Receive(args []interface{}) bool
+// The counters struct keeps the statistics of a subscription.
+type counters struct {
+	received uint64 // Accessed atomically.
+	rejected uint64 // Accessed atomically.
+	missed   uint64 // Accessed atomically.
+	latency  *stats.Histogram
 }
 
-// TODO: make *[]byte? Currently, the byte slice might is copied for every
-// call, which lowers performance. However, modules that (accidentally) modify
-// the data now do not interfere with eachother.
-type message struct {
-	topic string
-	args  []interface{}
+// SubscriberStats are the statistics of a subscriber.
+type SubscriberStats struct {
+	Subscriber Subscriber
+	// The number of events delivered to the subscriber.
+	Received uint64
+	// The number of PacketEvents the subscriber rejected.
+	Rejected uint64
+	// The number of events the subscriber did not answer before the
+	// deadline of a concurrent Hub, and so abstained from.
+	Missed uint64
+	// The time the subscriber took to handle its events.
+	Latency stats.HistogramSnapshot
 }
 
-type subscription struct {
-	topics  []string
-	handler func([]interface{}) bool
+// deliver passes an event to the subscriber and counts it.
+func (s subscription) deliver(e Event) bool {
+	start := time.Now()
+	ok := deliver(s.subscriber, e)
+	s.counters.latency.Observe(time.Since(start))
+	atomic.AddUint64(&s.counters.received, 1)
+	if !ok {
+		atomic.AddUint64(&s.counters.rejected, 1)
+	}
+	return ok
 }
 
-// The Hub struct is the "broker" through which all messages go.
+// matches reports whether any of the subscription's topics matches topic.
+func (s subscription) matches(topic string) bool {
+	for _, pattern := range s.topics {
+		if Match(pattern, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// The Hub struct is the "broker" through which all messages go. It is safe to
+// subscribe and unsubscribe while messages are being published.
 type Hub struct {
-	subscriptions map[string][]subscription
+	// The subscriptions in the order in which they were made, protected by
+	// mutex. Publish works on a snapshot, so that (un)subscribing during a
+	// publish does not affect it.
+	mutex         sync.RWMutex
+	subscriptions []subscription
+
+	// When concurrent is set, every subscriber receives the message on its
+	// own goroutine and the verdicts are combined according to policy.
+	// Subscribers that have not answered before the deadline abstain, so a
+	// slow subscriber fails open; a deadline of zero waits for all of them.
+	// The goroutines of late subscribers keep running until they answer,
+	// and their number is not bounded.
+	concurrent bool
+	policy     Policy
+	deadline   time.Duration
+
+	// The number of deliveries that are running on their own goroutine,
+	// including those that missed the deadline. Accessed atomically.
+	inFlight int64
 }
 
-// Create a new Hub.
+// Create a new Hub that dispatches to its subscribers serially.
 func NewHub() *Hub {
+	return &Hub{}
+}
+
+// Create a new Hub that dispatches to its subscribers concurrently, waits at
+// most deadline for their verdicts and combines them using policy.
+func NewConcurrentHub(policy Policy, deadline time.Duration) *Hub {
 	return &Hub{
-		subscriptions: make(map[string][]subscription),
+		concurrent: true,
+		policy:     policy,
+		deadline:   deadline,
 	}
 }
 
-// Publish the data to be passed to any subscriber subscribed to this topic.
-// Returns false as soon as one of the subscribers returns false, true
-// otherwise.
-func (h *Hub) Publish(topic string, args ...interface{}) bool {
+// Publish the event to be passed to any subscriber subscribed to its topic.
+// A serial Hub returns false as soon as one of the subscribers returns false,
+// true otherwise. A concurrent Hub lets every subscriber see the event and
+// returns the verdict of its policy. Only PacketEvents can be rejected.
+//
+// An AlertEvent created for a packet is added to the Alerts of its PacketEvent
+// before it is passed to the subscribers.
+func (h *Hub) Publish(e Event) bool {
+	if a, ok := e.(*AlertEvent); ok && a.origin != nil {
+		a.origin.addAlert(a)
+	}
+
 	// For each registered topic, it is checked if it matches the topic of
-	// the received message. If so, the message's arguments are sent to each
-	// subscriber subscribed to that topic.
-	subs := h.subscriptions[topic]
+	// the event. If so, the event is sent to each subscriber subscribed to
+	// that topic.
+	subs := h.matching(e.Topic())
+	if h.concurrent {
+		return h.publishConcurrent(subs, e)
+	}
 	for _, sub := range subs {
-		if ok := sub.handler(args); !ok {
+		if ok := sub.deliver(e); !ok {
 			return false
 		}
 	}
 	return true
 }
 
-// Subscribe subcribes a Subscriber for all its declared topics.
-func (h *Hub) Subscribe(s Subscriber) {
-	sub := subscription{s.Topics(), s.Receive}
-	for _, topic := range sub.topics {
-		h.subscriptions[topic] = append(h.subscriptions[topic], sub)
+func (h *Hub) publishConcurrent(subs []subscription, e Event) bool {
+	type verdict struct {
+		index int
+		ok    bool
+	}
+
+	// The channel is buffered so that subscribers answering after the
+	// deadline do not block forever.
+	verdicts := make(chan verdict, len(subs))
+	for i, sub := range subs {
+		atomic.AddInt64(&h.inFlight, 1)
+		go func(i int, sub subscription) {
+			defer atomic.AddInt64(&h.inFlight, -1)
+			verdicts <- verdict{i, sub.deliver(e)}
+		}(i, sub)
+	}
+
+	var timeout <-chan time.Time
+	if h.deadline > 0 {
+		timer := time.NewTimer(h.deadline)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var t tally
+	answered := make([]bool, len(subs))
+	for range subs {
+		select {
+		case v := <-verdicts:
+			answered[v.index] = true
+			t.add(subs[v.index].weight, v.ok)
+		case <-timeout:
+			for i, sub := range subs {
+				if !answered[i] {
+					atomic.AddUint64(&sub.counters.missed, 1)
+				}
+			}
+			return h.policy.verdict(t)
+		}
+	}
+	return h.policy.verdict(t)
+}
+
+// Subscribe subcribes a Subscriber for all its declared topics. It returns an
+// error, and does not subscribe, if the Subscriber does not implement the
+// handler for the events under one of its topics.
+func (h *Hub) Subscribe(s Subscriber) error {
+	return h.SubscribeWeighted(s, 1)
+}
+
+// SubscribeWeighted subscribes a Subscriber for all its declared topics, where
+// its verdicts carry the given weight under the Weighted policy.
+func (h *Hub) SubscribeWeighted(s Subscriber, weight int) error {
+	if !comparable(reflect.ValueOf(s)) {
+		return fmt.Errorf("%T cannot be compared, subscribe a pointer to it instead", s)
+	}
+	topics := s.Topics()
+	for _, topic := range topics {
+		if err := checkHandler(s, topic); err != nil {
+			return err
+		}
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.subscriptions = append(h.subscriptions, subscription{s, topics, weight, &counters{latency: stats.NewHistogram(stats.LatencyBuckets)}})
+	return nil
+}
+
+// Unsubscribe removes all subscriptions of a Subscriber. It returns false if
+// the Subscriber was not subscribed.
+func (h *Hub) Unsubscribe(s Subscriber) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	var subs []subscription
+	for _, sub := range h.subscriptions {
+		if !same(sub.subscriber, s) {
+			subs = append(subs, sub)
+		}
+	}
+	found := len(subs) != len(h.subscriptions)
+	h.subscriptions = subs
+	return found
+}
+
+// same reports whether a subscription's subscriber, which is comparable, is
+// s, which may not be.
+func same(subscriber, s Subscriber) bool {
+	return comparable(reflect.ValueOf(s)) && subscriber == s
+}
+
+// comparable reports whether v can be compared without panicking. Unlike the
+// Comparable method of its type, it looks into the values held by interfaces.
+func comparable(v reflect.Value) bool {
+	if !v.IsValid() {
+		return true
+	}
+	if !v.Type().Comparable() {
+		return false
+	}
+	switch v.Kind() {
+	case reflect.Interface:
+		return comparable(v.Elem())
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if !comparable(v.Index(i)) {
+				return false
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !comparable(v.Field(i)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matching returns a snapshot of the subscriptions to topic.
+func (h *Hub) matching(topic string) []subscription {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	var subs []subscription
+	for _, sub := range h.subscriptions {
+		if sub.matches(topic) {
+			subs = append(subs, sub)
+		}
 	}
+	return subs
+}
+
+// Stats returns the statistics of the current subscribers, in the order in
+// which they subscribed.
+func (h *Hub) Stats() []SubscriberStats {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	ss := make([]SubscriberStats, len(h.subscriptions))
+	for i, sub := range h.subscriptions {
+		ss[i] = SubscriberStats{
+			Subscriber: sub.subscriber,
+			Received:   atomic.LoadUint64(&sub.counters.received),
+			Rejected:   atomic.LoadUint64(&sub.counters.rejected),
+			Missed:     atomic.LoadUint64(&sub.counters.missed),
+			Latency:    sub.counters.latency.Snapshot(),
+		}
+	}
+	return ss
+}
+
+// InFlight returns the number of deliveries of a concurrent Hub that have not
+// returned yet, including those that missed their deadline.
+func (h *Hub) InFlight() int {
+	return int(atomic.LoadInt64(&h.inFlight))
 }
@@ -0,0 +1,306 @@
+package hub
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var packet = &PacketEvent{}
+
+type testSubscriber struct {
+	verdict bool
+	delay   time.Duration
+	seen    chan interface{}
+}
+
+func newTestSubscriber(verdict bool, delay time.Duration) *testSubscriber {
+	return &testSubscriber{verdict, delay, make(chan interface{}, 10)}
+}
+
+func (s *testSubscriber) Topics() []string {
+	return []string{"packet"}
+}
+
+func (s *testSubscriber) ReceivePacket(e *PacketEvent) bool {
+	time.Sleep(s.delay)
+	s.seen <- e
+	return s.verdict
+}
+
+func TestSerialShortCircuits(t *testing.T) {
+	h := NewHub()
+	drop := newTestSubscriber(false, 0)
+	accept := newTestSubscriber(true, 0)
+	assert.Nil(t, h.Subscribe(drop))
+	assert.Nil(t, h.Subscribe(accept))
+
+	assert := assert.New(t)
+	assert.Equal(false, h.Publish(packet))
+	assert.Equal(1, len(drop.seen))
+	assert.Equal(0, len(accept.seen))
+}
+
+func TestConcurrentAnyDrop(t *testing.T) {
+	h := NewConcurrentHub(AnyDrop, 0)
+	drop := newTestSubscriber(false, 0)
+	accept := newTestSubscriber(true, 0)
+	assert.Nil(t, h.Subscribe(drop))
+	assert.Nil(t, h.Subscribe(accept))
+
+	assert := assert.New(t)
+	assert.Equal(false, h.Publish(packet))
+	// Every subscriber observes the packet, even the one after the drop.
+	assert.Equal(packet, <-drop.seen)
+	assert.Equal(packet, <-accept.seen)
+}
+
+func TestConcurrentMajority(t *testing.T) {
+	h := NewConcurrentHub(Majority, 0)
+	assert.Nil(t, h.Subscribe(newTestSubscriber(false, 0)))
+	assert.Nil(t, h.Subscribe(newTestSubscriber(true, 0)))
+	assert.Nil(t, h.Subscribe(newTestSubscriber(true, 0)))
+	assert.Equal(t, true, h.Publish(packet))
+
+	assert.Nil(t, h.Subscribe(newTestSubscriber(false, 0)))
+	assert.Nil(t, h.Subscribe(newTestSubscriber(false, 0)))
+	assert.Equal(t, false, h.Publish(packet))
+}
+
+func TestConcurrentWeighted(t *testing.T) {
+	h := NewConcurrentHub(Weighted, 0)
+	assert.Nil(t, h.SubscribeWeighted(newTestSubscriber(false, 0), 3))
+	assert.Nil(t, h.Subscribe(newTestSubscriber(true, 0)))
+	assert.Nil(t, h.Subscribe(newTestSubscriber(true, 0)))
+	assert.Equal(t, false, h.Publish(packet))
+}
+
+func TestConcurrentDeadline(t *testing.T) {
+	h := NewConcurrentHub(AnyDrop, 10*time.Millisecond)
+	slow := newTestSubscriber(false, time.Second)
+	assert.Nil(t, h.Subscribe(slow))
+	assert.Nil(t, h.Subscribe(newTestSubscriber(true, 0)))
+
+	// The slow subscriber misses the deadline and abstains.
+	assert.Equal(t, true, h.Publish(packet))
+	stats := h.Stats()
+	assert.Equal(t, uint64(1), stats[0].Missed)
+	assert.Equal(t, uint64(0), stats[1].Missed)
+}
+
+func TestParsePolicy(t *testing.T) {
+	assert := assert.New(t)
+	for _, p := range []Policy{AnyDrop, Majority, Weighted} {
+		parsed, err := ParsePolicy(p.String())
+		assert.Nil(err)
+		assert.Equal(p, parsed)
+	}
+
+	_, err := ParsePolicy("unanimous")
+	assert.EqualError(err, "Unknown verdict policy: unanimous")
+}
+
+func TestUnsubscribe(t *testing.T) {
+	h := NewHub()
+	drop := newTestSubscriber(false, 0)
+	assert.Nil(t, h.Subscribe(drop))
+
+	assert := assert.New(t)
+	assert.Equal(false, h.Publish(packet))
+	assert.Equal(true, h.Unsubscribe(drop))
+	assert.Equal(true, h.Publish(packet))
+	assert.Equal(false, h.Unsubscribe(drop))
+}
+
+func TestStats(t *testing.T) {
+	h := NewHub()
+	accept := newTestSubscriber(true, 0)
+	drop := newTestSubscriber(false, 0)
+	assert.Nil(t, h.Subscribe(accept))
+	assert.Nil(t, h.Subscribe(drop))
+	h.Publish(packet)
+	h.Publish(packet)
+
+	assert := assert.New(t)
+	stats := h.Stats()
+	assert.Equal(2, len(stats))
+	assert.Equal(accept, stats[0].Subscriber)
+	assert.Equal(uint64(2), stats[0].Received)
+	assert.Equal(uint64(0), stats[0].Rejected)
+	assert.Equal(uint64(2), stats[0].Latency.Count)
+	assert.Equal(drop, stats[1].Subscriber)
+	assert.Equal(uint64(2), stats[1].Received)
+	assert.Equal(uint64(2), stats[1].Rejected)
+	assert.Equal(uint64(0), stats[1].Missed)
+	assert.Equal(0, h.InFlight())
+}
+
+func TestMatch(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(true, Match("packet", "packet"))
+	assert.Equal(false, Match("packet", "log"))
+	assert.Equal(true, Match("log.*", "log.error"))
+	assert.Equal(false, Match("log.*", "log"))
+	assert.Equal(false, Match("log.*", "log.error.arp"))
+	assert.Equal(true, Match("*.error", "log.error"))
+	assert.Equal(true, Match("alert.arp.#", "alert.arp"))
+	assert.Equal(true, Match("alert.arp.#", "alert.arp.notice"))
+	assert.Equal(true, Match("alert.arp.#", "alert.arp.notice.spurious"))
+	assert.Equal(false, Match("alert.arp.#", "alert.wifi.notice"))
+	assert.Equal(true, Match("#", "anything.at.all"))
+	assert.Equal(true, Match("alert.#.error", "alert.arp.error"))
+	assert.Equal(true, Match("alert.#.error", "alert.error"))
+	assert.Equal(false, Match("alert.#.error", "alert.arp.notice"))
+}
+
+type testAlertSubscriber struct {
+	alerts []*AlertEvent
+}
+
+func (s *testAlertSubscriber) Topics() []string {
+	return []string{"alert.arp.#"}
+}
+
+func (s *testAlertSubscriber) ReceiveAlert(e *AlertEvent) {
+	s.alerts = append(s.alerts, e)
+}
+
+func TestAlertTopics(t *testing.T) {
+	h := NewHub()
+	s := &testAlertSubscriber{}
+	assert.Nil(t, h.Subscribe(s))
+
+	arp := &AlertEvent{Severity: Error, Module: "arp"}
+	h.Publish(arp)
+	h.Publish(&AlertEvent{Severity: Notice, Module: "wifi"})
+
+	assert := assert.New(t)
+	assert.Equal("alert.arp.error", arp.Topic())
+	assert.Equal([]*AlertEvent{arp}, s.alerts)
+}
+
+func TestSubscribeWithoutHandler(t *testing.T) {
+	h := NewHub()
+	err := h.Subscribe(&testAlertSubscriber{})
+	assert.Nil(t, err)
+
+	err = h.Subscribe(&wrongSubscriber{})
+	assert.EqualError(t, err, "*hub.wrongSubscriber subscribes to packet but cannot handle its events")
+
+	err = h.Subscribe(&unknownSubscriber{})
+	assert.EqualError(t, err, "Unknown topic: error")
+}
+
+type wrongSubscriber struct {
+	testAlertSubscriber
+}
+
+func (s *wrongSubscriber) Topics() []string {
+	return []string{"packet"}
+}
+
+type unknownSubscriber struct {
+	testAlertSubscriber
+}
+
+func (s *unknownSubscriber) Topics() []string {
+	return []string{"error"}
+}
+
+type testLegacySubscriber struct {
+	args [][]interface{}
+}
+
+func (s *testLegacySubscriber) Topics() []string {
+	return []string{"packet", "log"}
+}
+
+func (s *testLegacySubscriber) Receive(args []interface{}) bool {
+	s.args = append(s.args, args)
+	return false
+}
+
+func TestLegacy(t *testing.T) {
+	h := NewHub()
+	s := &testLegacySubscriber{}
+	assert.Nil(t, h.Subscribe(Legacy(s)))
+
+	assert := assert.New(t)
+	assert.Equal(false, h.Publish(packet))
+	h.Publish(&AlertEvent{Severity: Notice, Module: "arp", Message: "message"})
+	assert.Equal([][]interface{}{{packet.Packet}, {"notice", "message"}}, s.args)
+
+	assert.Equal(true, h.Unsubscribe(Legacy(s)))
+}
+
+// A subscriber that cannot be compared, since it holds a slice.
+type sliceSubscriber struct {
+	topics []string
+}
+
+func (s sliceSubscriber) Topics() []string {
+	return s.topics
+}
+
+func (s sliceSubscriber) ReceivePacket(e *PacketEvent) bool {
+	return true
+}
+
+func (s sliceSubscriber) Receive(args []interface{}) bool {
+	return true
+}
+
+func TestSubscribeIncomparable(t *testing.T) {
+	h := NewHub()
+	assert := assert.New(t)
+	s := sliceSubscriber{[]string{"packet"}}
+	assert.EqualError(h.Subscribe(s), "hub.sliceSubscriber cannot be compared, subscribe a pointer to it instead")
+	assert.EqualError(h.Subscribe(Legacy(s)), "hub.Adapter cannot be compared, subscribe a pointer to it instead")
+	assert.Nil(h.Subscribe(&s))
+
+	assert.Equal(false, h.Unsubscribe(s))
+	assert.Equal(false, h.Unsubscribe(Legacy(s)))
+	assert.Equal(true, h.Unsubscribe(&s))
+}
+
+func TestSubscribeWhilePublishing(t *testing.T) {
+	for _, h := range []*Hub{NewHub(), NewConcurrentHub(AnyDrop, time.Millisecond)} {
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+						h.Publish(packet)
+					}
+				}
+			}()
+		}
+
+		// Subscribers come and go, and can be found in the statistics
+		// by their value, while packets are being published.
+		for i := 0; i < 100; i++ {
+			s := &sliceSubscriber{[]string{"packet"}}
+			legacy := Legacy(&sliceSubscriber{[]string{"packet"}})
+			assert.Nil(t, h.Subscribe(s))
+			assert.Nil(t, h.Subscribe(legacy))
+			names := make(map[Subscriber]int)
+			for i, stats := range h.Stats() {
+				names[stats.Subscriber] = i
+			}
+			assert.Len(t, names, 2)
+			assert.Equal(t, true, h.Unsubscribe(s))
+			assert.Equal(t, true, h.Unsubscribe(legacy))
+		}
+		close(stop)
+		wg.Wait()
+		assert.Len(t, h.Stats(), 0)
+	}
+}
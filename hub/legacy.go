@@ -0,0 +1,71 @@
+package hub
+
+// A LegacySubscriber is a subscriber written before the hub carried typed
+// events: it receives the contents of every message as a list of arbitrary
+// arguments and is itself responsible for converting them to the correct type.
+type LegacySubscriber interface {
+	// Topics returns an array of topics that the subscriber subcribes to.
+	Topics() []string
+
+	// Receive is called when there is a message under a certain topic to
+	// which the subscriber has subscribed. The subscriber passes its
+	// verdict in the return value, where true means the packet is safe and
+	// false means it's not.
+	Receive(args []interface{}) bool
+}
+
+// Legacy adapts a LegacySubscriber to the typed events on the hub. Events are
+// converted to the arguments the old topics used to carry:
+//
+// 1. "packet": the gopacket.Packet;
+// 2. "log": the severity and the message of an alert, both strings;
+// 3. "metric": the name and the value of a metric;
+// 4. "control": the command followed by its arguments.
+//
+// The topic "log" is translated to "alert.#". Since the adapter compares equal
+// when the adapted subscribers do, Unsubscribe(Legacy(s)) removes it again; as
+// for any Subscriber, s must be comparable.
+func Legacy(s LegacySubscriber) Adapter {
+	return Adapter{s}
+}
+
+// An Adapter implements Subscriber and all event handlers on behalf of a
+// LegacySubscriber.
+type Adapter struct {
+	s LegacySubscriber
+}
+
+func (l Adapter) Topics() []string {
+	var topics []string
+	for _, topic := range l.s.Topics() {
+		switch topic {
+		case "log":
+			topics = append(topics, TopicAlert+separator+wildMany)
+		case TopicMetric, TopicControl:
+			topics = append(topics, topic+separator+wildMany)
+		default:
+			topics = append(topics, topic)
+		}
+	}
+	return topics
+}
+
+func (l Adapter) ReceivePacket(e *PacketEvent) bool {
+	return l.s.Receive([]interface{}{e.Packet})
+}
+
+func (l Adapter) ReceiveAlert(e *AlertEvent) {
+	l.s.Receive([]interface{}{e.Severity.String(), e.Message})
+}
+
+func (l Adapter) ReceiveMetric(e *MetricEvent) {
+	l.s.Receive([]interface{}{e.Name, e.Value})
+}
+
+func (l Adapter) ReceiveControl(e *ControlEvent) {
+	args := []interface{}{e.Command}
+	for _, arg := range e.Args {
+		args = append(args, arg)
+	}
+	l.s.Receive(args)
+}
@@ -0,0 +1,74 @@
+package hub
+
+import "fmt"
+
+// A Policy decides how the verdicts of all subscribers to a topic are combined
+// into a single verdict when the Hub dispatches concurrently.
+type Policy int
+
+// Policy values.
+const (
+	// AnyDrop drops a packet as soon as one subscriber drops it.
+	AnyDrop Policy = iota
+	// Majority drops a packet when more than half of the subscribers that
+	// answered in time drop it.
+	Majority
+	// Weighted drops a packet when the summed weight of the subscribers
+	// dropping it exceeds the summed weight of those accepting it.
+	Weighted
+)
+
+// String returns a string representation of the Policy.
+func (p Policy) String() string {
+	switch p {
+	case AnyDrop:
+		return "any-drop"
+	case Majority:
+		return "majority"
+	case Weighted:
+		return "weighted"
+	default:
+		return "N/A"
+	}
+}
+
+// ParsePolicy returns the Policy named by s, see Policy.String.
+func ParsePolicy(s string) (Policy, error) {
+	for _, p := range []Policy{AnyDrop, Majority, Weighted} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return AnyDrop, fmt.Errorf("Unknown verdict policy: %s", s)
+}
+
+// A tally keeps count of the verdicts received so far.
+type tally struct {
+	accepts      int
+	drops        int
+	acceptWeight int
+	dropWeight   int
+}
+
+func (t *tally) add(weight int, ok bool) {
+	if ok {
+		t.accepts++
+		t.acceptWeight += weight
+	} else {
+		t.drops++
+		t.dropWeight += weight
+	}
+}
+
+// verdict combines the tallied verdicts according to the policy. Subscribers
+// that have not answered abstain; if nobody answered the packet is accepted.
+func (p Policy) verdict(t tally) bool {
+	switch p {
+	case Majority:
+		return t.drops <= t.accepts
+	case Weighted:
+		return t.dropWeight <= t.acceptWeight
+	default:
+		return t.drops == 0
+	}
+}
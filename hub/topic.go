@@ -0,0 +1,54 @@
+package hub
+
+import "strings"
+
+// Topics are hierarchical: their levels are separated by dots, e.g.
+// "alert.arp.notice". A subscriber may use two wildcards in the topics it
+// subscribes to:
+//
+// 1. "*" matches exactly one level, e.g. "log.*" matches "log.error" but
+// neither "log" nor "log.error.arp";
+// 2. "#" matches zero or more levels, e.g. "alert.arp.#" matches "alert.arp",
+// "alert.arp.notice" and "alert.arp.notice.spurious".
+const (
+	separator = "."
+	wildOne   = "*"
+	wildMany  = "#"
+)
+
+// Match reports whether topic matches pattern.
+func Match(pattern, topic string) bool {
+	return match(strings.Split(pattern, separator), strings.Split(topic, separator))
+}
+
+func match(pattern, topic []string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case wildMany:
+			// Try to let the wildcard absorb zero, one, two, ...
+			// levels of the topic.
+			for i := 0; i <= len(topic); i++ {
+				if match(pattern[1:], topic[i:]) {
+					return true
+				}
+			}
+			return false
+		case wildOne:
+			if len(topic) == 0 {
+				return false
+			}
+		default:
+			if len(topic) == 0 || pattern[0] != topic[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		topic = topic[1:]
+	}
+	return len(topic) == 0
+}
+
+// root returns the first level of topic.
+func root(topic string) string {
+	return strings.SplitN(topic, separator, 2)[0]
+}
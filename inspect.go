@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/Hjdskes/ET4397IN/capture"
+	"github.com/Hjdskes/ET4397IN/clock"
+	"github.com/Hjdskes/ET4397IN/hub"
+	"github.com/Hjdskes/ET4397IN/pipeline"
+	"github.com/google/gopacket"
+)
+
+// inspect returns a pipeline of workers passing the packets to the modules on
+// the hub, and publishing the verdicts on them.
+//
+// If the modules tell the time by packetClock, which is advanced to every
+// packet before it is inspected, a single worker inspects all packets
+// regardless of the number of workers asked for. With more, tickers such as
+// the one of the DoS module would fire at different packets, and packets of
+// different flows would be inspected in a different order, every run.
+func inspect(workers, depth int, policy pipeline.OverloadPolicy, h *hub.Hub, packetClock *clock.Packet) *pipeline.Pipeline {
+	if packetClock != nil {
+		workers = 1
+	}
+	return pipeline.New(workers, depth, policy, func(packet gopacket.Packet) bool {
+		if packetClock != nil {
+			packetClock.Advance(packet.Metadata().Timestamp)
+		}
+		p := packet.(capture.Packet)
+		e := &hub.PacketEvent{Packet: p.Packet, Interface: p.Interface, LinkType: p.LinkType}
+		ok := h.Publish(e)
+		h.Publish(&hub.VerdictEvent{Packet: e, Accept: ok})
+		return ok
+	})
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Hjdskes/ET4397IN/capture"
+	"github.com/Hjdskes/ET4397IN/clock"
+	"github.com/Hjdskes/ET4397IN/config"
+	"github.com/Hjdskes/ET4397IN/hub"
+	"github.com/Hjdskes/ET4397IN/module"
+	"github.com/Hjdskes/ET4397IN/pipeline"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+)
+
+// The alerts struct records the alerts raised during a replay.
+type alerts struct {
+	mutex sync.Mutex
+	seen  []string
+}
+
+func (a *alerts) Topics() []string {
+	return []string{"alert.#"}
+}
+
+func (a *alerts) ReceiveAlert(e *hub.AlertEvent) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.seen = append(a.seen, fmt.Sprintf("%s %s: %s", e.Time.UTC().Format("15:04:05.000000"), e.Module, e.Message))
+}
+
+// replay inspects the packets of the capture files with the given modules as
+// the IPS does, and returns the alerts they raised.
+func replay(t *testing.T, paths []string, modules []string, workers int) []string {
+	c, err := config.New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := hub.NewHub()
+	packetClock := clock.NewPacket()
+	env := &module.Environment{Hub: h, Clock: packetClock}
+	var active []module.Module
+	for _, name := range modules {
+		m, err := module.New(name, env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Init(c); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.Subscribe(m); err != nil {
+			t.Fatal(err)
+		}
+		active = append(active, m)
+	}
+	a := &alerts{}
+	if err := h.Subscribe(a); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := capture.NewReplay(paths, capture.ReplayOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl := inspect(workers, 16, pipeline.Block, h, packetClock)
+	for packet := range src.Packets() {
+		pl.Submit(packet, packet.Verdict)
+	}
+	pl.Close()
+	for _, m := range active {
+		m.Flush()
+		m.Close()
+	}
+	src.Close()
+	return a.seen
+}
+
+// writeARP writes a capture file in which many hosts ask for the address of
+// their neighbour, which answers a millisecond later. The requests and replies
+// are different flows, so with several workers a reply could be inspected
+// before its request and be taken for a spurious reply.
+func writeARP(t *testing.T, path string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := pcapgo.NewWriter(f)
+	if err := w.WriteFileHeader(65535, layers.LinkTypeEthernet); err != nil {
+		t.Fatal(err)
+	}
+
+	at := time.Date(2016, 11, 24, 21, 27, 9, 0, time.UTC)
+	host := func(i int) (net.HardwareAddr, net.IP) {
+		return net.HardwareAddr{2, 0, 0, 0, byte(i >> 8), byte(i)}, net.IP{10, 0, byte(i >> 8), byte(i)}
+	}
+	write := func(op uint16, i, j int, dst net.HardwareAddr) {
+		srcMAC, srcIP := host(i)
+		dstMAC, dstIP := host(j)
+		if op == layers.ARPRequest {
+			dstMAC = net.HardwareAddr{0, 0, 0, 0, 0, 0}
+		}
+		eth := &layers.Ethernet{SrcMAC: srcMAC, DstMAC: dst, EthernetType: layers.EthernetTypeARP}
+		a := &layers.ARP{
+			AddrType: layers.LinkTypeEthernet, Protocol: layers.EthernetTypeIPv4,
+			HwAddressSize: 6, ProtAddressSize: 4, Operation: op,
+			SourceHwAddress: srcMAC, SourceProtAddress: srcIP,
+			DstHwAddress: dstMAC, DstProtAddress: dstIP,
+		}
+		buffer := gopacket.NewSerializeBuffer()
+		if err := gopacket.SerializeLayers(buffer, gopacket.SerializeOptions{}, eth, a); err != nil {
+			t.Fatal(err)
+		}
+		at = at.Add(time.Millisecond)
+		ci := gopacket.CaptureInfo{Timestamp: at, CaptureLength: len(buffer.Bytes()), Length: len(buffer.Bytes())}
+		if err := w.WritePacket(ci, buffer.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	broadcast := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	for i := 1; i < 500; i++ {
+		requester, _ := host(i)
+		write(layers.ARPRequest, i, i+1, broadcast)
+		write(layers.ARPReply, i+1, i, requester)
+	}
+}
+
+func TestReplayDeterministic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	generated := filepath.Join(dir, "arp.pcap")
+	writeARP(t, generated)
+
+	paths := []string{generated, "functional/arp.pcap", "functional/802.11w-disassociationexample-wpahandshake.pcapng"}
+	modules := []string{"arp", "wifi"}
+	first := replay(t, paths, modules, 8)
+
+	assert := assert.New(t)
+	assert.NotEmpty(first)
+	for i := 0; i < 10; i++ {
+		assert.Equal(first, replay(t, paths, modules, 8))
+	}
+}
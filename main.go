@@ -1,150 +1,484 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"os"
-	"sync"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/Hjdskes/ET4397IN/capture"
+	"github.com/Hjdskes/ET4397IN/clock"
 	"github.com/Hjdskes/ET4397IN/config"
+	"github.com/Hjdskes/ET4397IN/control"
 	"github.com/Hjdskes/ET4397IN/hub"
+	"github.com/Hjdskes/ET4397IN/metrics"
 	"github.com/Hjdskes/ET4397IN/module"
+	"github.com/Hjdskes/ET4397IN/pipeline"
+	"github.com/Hjdskes/ET4397IN/rewrite"
 	"github.com/google/gopacket"
-	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
-	"github.com/google/gopacket/pcapgo"
 )
 
 func main() {
-	var handle *pcap.Handle
+	var src capture.Source
 	var configuration *config.Configuration
-	var w *pcapgo.Writer
 	var err error
 
 	// Process command-line arguments.
-	device := flag.String("device", "enp9s0", "The device to capture packets from.")
+	device := flag.String("device", "enp9s0", "Comma separated list of devices to capture packets from. Packets are tagged with the device they were captured on.")
+	insideDevice := flag.String("inside", "", "Bridge this device and --outside, forwarding only the frames that are accepted. (default none)")
+	outsideDevice := flag.String("outside", "", "Bridge this device and --inside, forwarding only the frames that are accepted. (default none)")
 	snaplen := flag.Int("snaplen", 65535, "The maximum size to read for each packet.")
 	promiscuous := flag.Bool("promiscuous", false, "Put the device in promiscuous mode. (default false)")
-	filePath := flag.String("path", "", "Save the recorded packets into a file specified by this flag. (default none)")
-	source := flag.String("source", "", "Read packets from the file specified by this flag. (default none; read from device)")
+	filePath := flag.String("path", "", "Save the recorded packets into the files specified by this flag, which may contain strftime-like placeholders such as %Y%m%d-%H%M%S; see the write section of the configuration for rotation. (default none)")
+	source := flag.String("source", "", "Comma separated list of files to read packets from, merged in the order of their timestamps. (default none; read from --device, or from both if it is given explicitly)")
+	replaySpeed := flag.String("replay-speed", "max", "Replay --source at this multiple of the speed at which it was captured, e.g. 1x or 10x, or as fast as possible with max.")
+	replayLoop := flag.Bool("replay-loop", false, "Replay --source over and over until interrupted. (default false)")
+	replayInject := flag.String("replay-inject", "", "Write the packets replayed from --source onto this device as they are replayed. (default none)")
 	filter := flag.String("filter", "", "Set a BPF. (default none)")
+	captureBackend := flag.String("capture-backend", "pcap", "Capture from --device with pcap, or with afpacket: memory-mapped TPACKET_V3 ring buffers shared by the sockets of a fanout group, configured in the afpacket section of the configuration.")
+	inline := flag.Bool("inline", false, "Read packets from the netfilter queues in the nfqueue section of the configuration instead of from a device, so that dropped packets never reach their destination.")
 	configFile := flag.String("config", "", "Path to the configuration file")
+	policy := flag.String("policy", "", "Dispatch packets to all modules concurrently and combine their verdicts using this policy: any-drop, majority or weighted. (default none; dispatch serially)")
+	deadline := flag.Duration("deadline", 10*time.Millisecond, "The time to wait for the verdicts of all modules when dispatching concurrently. Modules that answer later abstain, so a slow module fails open; its delivery keeps running, unbounded, until it answers. Zero waits for all modules.")
+	workers := flag.Int("workers", runtime.NumCPU(), "The number of workers inspecting packets. All packets of a flow are inspected by the same worker. Packets read only from --source are always inspected by a single worker.")
+	queueDepth := flag.Int("queue-depth", 1024, "The maximum number of packets waiting for each worker.")
+	overload := flag.String("overload", "", "What to do with a packet when its worker's queue is full: drop-newest, drop-oldest, fail-open, fail-closed or block. (default drop-newest; with --source, block; with --inline, fail-open or fail-closed following nfqueue.fail-open)")
+	modules := flag.String("modules", "", "Comma separated list of modules to run, in order, as name[:weight]. If every entry starts with + or -, the modules are added to or removed from the list in the configuration file. (default from the configuration)")
+	listModules := flag.Bool("list-modules", false, "List the available modules and exit.")
+	var overrides config.Overrides
+	flag.Var(&overrides, "set", "Override a configuration value, as key=value with the key being the dotted path of the value, e.g. dos.syn-threshold=10. May be repeated; takes precedence over the configuration file and the ET_ environment variables.")
+	printConfig := flag.Bool("print-config", false, "Print the effective configuration, after applying the configuration file, the environment and --set, as JSON and exit.")
+	checkConfig := flag.Bool("check-config", false, "Check the configuration file and the selected modules, report all problems and exit; the exit status is non-zero if there are any.")
+	watchInterval := flag.Duration("watch-interval", 2*time.Second, "How often to check the configuration file for changes, which are then reloaded as on SIGHUP. (0 disables watching)")
+	metricsListen := flag.String("metrics-listen", "", "Serve the metrics in the Prometheus text format on /metrics at this address, e.g. localhost:9100. (default none)")
+	controlSocket := flag.String("control-socket", "", "Serve the control API on a Unix socket at this path, to inspect and steer the modules while running. (default none)")
+	statsInterval := flag.Duration("stats-interval", time.Minute, "How often to log the statistics of the capture, the workers and the modules; they are always logged on shutdown. (0 disables periodic logging)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 5*time.Second, "The maximum time to wait for in-flight packets and modules when shutting down.")
 	flag.Parse()
 
-	if *source != "" {
-		// If a source file is specified, read all packets from that file.
-		handle, err = pcap.OpenOffline(*source)
+	// Capture from the devices as well as from the files if both are
+	// given, but only from the files if --device is left at its default.
+	live := *source == ""
+	flag.Visit(func(f *flag.Flag) {
+		live = live || f.Name == "device"
+	})
+
+	if *listModules {
+		for _, name := range module.Names() {
+			fmt.Printf("%-8s %s\n", name, module.Describe(name))
+		}
+		return
+	}
+
+	// Read the configuration file; if none is given, the defaults are
+	// applied. Environment variables and --set override its values. The
+	// names of the modules are checked here, since only the module package
+	// knows them.
+	configuration, err = config.New(*configFile, overrides...)
+	var selection []module.Selection
+	if err == nil {
+		selection, err = module.Select(configuration.Modules, *modules)
+	}
+	if *checkConfig || *printConfig {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if *printConfig {
+			out, err := json.MarshalIndent(configuration, "", "\t")
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(string(out))
+		} else {
+			fmt.Println("Configuration OK")
+		}
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Every packet that is not inspected must still receive a verdict in
+	// inline mode, or the kernel holds on to it forever.
+	nfqueueConfig := configuration.Section("nfqueue").(*capture.NFQueueConfig)
+	if *overload == "" {
+		*overload = pipeline.DropNewest.String()
+		if *inline && nfqueueConfig.FailOpen {
+			*overload = pipeline.FailOpen.String()
+		} else if *inline {
+			*overload = pipeline.FailClosed.String()
+		} else if !live {
+			*overload = pipeline.Block.String()
+		}
+	}
+	overloadPolicy, err := pipeline.ParseOverloadPolicy(*overload)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *inline && (overloadPolicy == pipeline.DropNewest || overloadPolicy == pipeline.DropOldest) {
+		log.Fatal("Inline mode requires --overload=fail-open, fail-closed or block")
+	}
+
+	// Accepted packets are rewritten by the rules in the configuration. A
+	// passive capture forwards all remaining IPv4 packets to the forwarding
+	// IP address, which has been validated as an IPv4 address.
+	bridge := *insideDevice != "" || *outsideDevice != ""
+	rewriteRules := func(c *config.Configuration) ([]rewrite.Rule, time.Duration) {
+		section := c.Section("rewrite").(*rewrite.Config)
+		rules := section.Compile()
+		if !bridge {
+			rules = append(rules, rewrite.Forward(net.ParseIP(c.ForwardIP).To4()))
+		}
+		return rules, section.Timeout.Duration
+	}
+	rewriter := rewrite.New(rewriteRules(configuration))
+
+	if *captureBackend != "pcap" && *captureBackend != "afpacket" {
+		log.Fatal("Invalid capture backend: " + *captureBackend)
+	}
+	if *captureBackend == "afpacket" && (*inline || bridge || !live) {
+		log.Fatal("--capture-backend=afpacket requires --device, and cannot be used with --inline, --inside and --outside")
+	}
+	if *captureBackend == "afpacket" && *promiscuous {
+		log.Fatal("--promiscuous cannot be used with --capture-backend=afpacket; use ip link set DEVICE promisc on")
+	}
+
+	if *inline {
+		// The kernel hands us the packets and waits for the verdicts.
+		if *source != "" || *filter != "" || bridge {
+			log.Fatal("--source, --filter, --inside and --outside cannot be used with --inline")
+		}
+		src, err = capture.NewNFQueue(nfqueueConfig)
 		if err != nil {
 			log.Fatal(err)
 		}
-		defer handle.Close()
-	} else {
-		// No source file was specified, so we open the device and read
-		// the packets from there.
-		handle, err = pcap.OpenLive(*device, int32(*snaplen), *promiscuous, pcap.BlockForever)
+	} else if bridge {
+		// Sit between two devices and forward the accepted frames.
+		if *insideDevice == "" || *outsideDevice == "" || *source != "" {
+			log.Fatal("Bridge mode requires both --inside and --outside, and cannot be used with --source")
+		}
+		var handles []*pcap.Handle
+		for _, device := range []string{*insideDevice, *outsideDevice} {
+			handle, err := pcap.OpenLive(device, int32(*snaplen), true, pcap.BlockForever)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if *filter != "" {
+				// Frames that do not match the BPF are not
+				// forwarded at all.
+				if err = handle.SetBPFFilter(*filter); err != nil {
+					log.Fatal(err)
+				}
+			}
+			handles = append(handles, handle)
+		}
+		src, err = capture.NewBridge(*insideDevice, handles[0], *outsideDevice, handles[1], rewriter.Rewrite)
 		if err != nil {
 			log.Fatal(err)
 		}
-		defer handle.Close()
-
-		// If a file path was specified, open said file to write the packets to.
-		if *filePath != "" {
-			f, err := os.Create(*filePath)
+	} else {
+		var sources []capture.Source
+		if *source != "" {
+			// If source files are specified, replay all packets
+			// from those files.
+			options := capture.ReplayOptions{Loop: *replayLoop, Filter: *filter}
+			options.Speed, err = capture.ParseSpeed(*replaySpeed)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if *replayInject != "" {
+				options.Inject, err = pcap.OpenLive(*replayInject, int32(*snaplen), false, pcap.BlockForever)
+				if err != nil {
+					log.Fatal(err)
+				}
+			}
+			replay, err := capture.NewReplay(strings.Split(*source, ","), options)
 			if err != nil {
-				log.Print(err)
-			} else {
-				w = pcapgo.NewWriter(f)
-				// Write the header into the file.
-				w.WriteFileHeader(uint32(*snaplen), layers.LinkTypeEthernet)
-				defer f.Close()
+				log.Fatal(err)
+			}
+			sources = append(sources, replay)
+		}
+
+		if live {
+			// Open the devices and read the packets from there.
+			for i, device := range strings.Split(*device, ",") {
+				if *captureBackend == "afpacket" {
+					afpacketConfig := configuration.Section("afpacket").(*capture.AFPacketConfig)
+					s, err := capture.NewAFPacket(device, i, *snaplen, *filter, afpacketConfig, rewriter.Rewrite)
+					if err != nil {
+						log.Fatal(err)
+					}
+					sources = append(sources, s)
+					continue
+				}
+
+				handle, err := pcap.OpenLive(device, int32(*snaplen), *promiscuous, pcap.BlockForever)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				if *filter != "" {
+					// If a BPF is given, apply it.
+					err = handle.SetBPFFilter(*filter)
+					if err != nil {
+						log.Fatal(err)
+					}
+				}
+
+				sources = append(sources, capture.NewPcap(handle, device, func(packet gopacket.Packet) {
+					forward(handle, packet, rewriter)
+				}))
 			}
 		}
+		// A pcap file has a single link type, unlike a pcapng file.
+		classic := configuration.Section("write").(*module.WriteConfig).Format == "pcap"
+		for _, s := range sources {
+			if *filePath != "" && classic && s.LinkType() != sources[0].LinkType() {
+				log.Fatal("Cannot save packets of different link types into a single file")
+			}
+		}
+		src = capture.Merge(sources...)
 	}
 
-	if *filter != "" {
-		// If a BPF is given, apply it.
-		err = handle.SetBPFFilter(*filter)
+	// Create the message hub.
+	var h *hub.Hub
+	if *policy != "" {
+		p, err := hub.ParsePolicy(*policy)
 		if err != nil {
 			log.Fatal(err)
 		}
+		h = hub.NewConcurrentHub(p, *deadline)
+	} else {
+		h = hub.NewHub()
 	}
 
-	// Read the configuration file; if it can't be found or if something
-	// else goes wrong the defaults are applied.
-	configuration, err = config.New(*configFile)
-	if err != nil {
-		log.Println(err)
+	// If a file path was specified while capturing from a device, the
+	// WriteModule is appended to save the packets into said file.
+	if *filePath != "" && live {
+		write := false
+		for _, s := range selection {
+			write = write || s.Name == "write"
+		}
+		if !write {
+			selection = append(selection, module.Selection{Name: "write", Weight: 1})
+		}
 	}
 
-	// Parse and set the forwarding IP address.
-	fwdIP := net.ParseIP(configuration.ForwardIP)
-	if fwdIP == nil {
-		log.Fatal("Can't parse forwarding IP address: %s\n", configuration.ForwardIP)
+	// Create and initialize all modules and subscribe them on the bus. If
+	// a module cannot be initialized, it is not subscribed on the bus.
+	// When reading a capture file, the modules tell the time by the
+	// timestamps of the packets rather than by how fast the file is read.
+	var packetClock *clock.Packet
+	env := &module.Environment{Hub: h, Clock: clock.Wall(), Path: *filePath, Snaplen: *snaplen, LinkType: src.LinkType()}
+	if !live {
+		packetClock = clock.NewPacket()
+		env.Clock = packetClock
 	}
-	fwdIP = fwdIP.To4()
-	if fwdIP == nil {
-		log.Fatal("Can't convert forwarding IP address to IPv4: %s\n", configuration.ForwardIP)
+	var active []module.Module
+	names := make(map[hub.Subscriber]string)
+	ctl := &controller{
+		h:             h,
+		path:          *configFile,
+		overrides:     overrides,
+		configuration: configuration,
+		changed: func(c *config.Configuration) {
+			rewriter.SetRules(rewriteRules(c))
+		},
+	}
+	for _, s := range selection {
+		m, err := module.New(s.Name, env)
+		if err == nil {
+			err = m.Init(configuration)
+		}
+		if err == nil {
+			err = h.SubscribeWeighted(m, s.Weight)
+			if err != nil {
+				m.Close()
+			}
+		}
+		if err != nil {
+			log.Println(err)
+		} else {
+			active = append(active, m)
+			names[m] = s.Name
+			ctl.modules = append(ctl.modules, &running{m, s.Name, s.Weight, true})
+		}
 	}
 
-	// Create the message hub.
-	hub := hub.NewHub()
+	// Create the pipeline of workers that pass the packets to the modules.
+	pl := inspect(*workers, *queueDepth, overloadPolicy, h, packetClock)
 
-	// Create all the modules.
-	// TODO: make the selection of modules configurable on the command-line
-	var mutex = &sync.Mutex{}
-	modules := []module.Module{
-		//&module.ARPModule{Hub: hub},
-		&module.DoSModule{Hub: hub, Mutex: mutex},
-		//module.DNSModule{},
-		module.LogModule{},
-		//&module.WiFiModule{Hub: hub},
+	// Log the statistics periodically.
+	st := &statistics{src: src, pl: pl, h: h, names: names}
+	stopStats := make(chan struct{})
+	if *statsInterval > 0 {
+		go st.run(*statsInterval, stopStats)
 	}
 
-	// If there is a writer, append the WriteModule to the list of modules.
-	if w != nil {
-		modules = append(modules, module.WriteModule{Writer: w})
+	// Serve the statistics and the metrics reported by the modules.
+	var metricsServer *http.Server
+	if *metricsListen != "" {
+		registry := metrics.NewRegistry()
+		registry.Register(st.collect)
+		if err := h.Subscribe(&metrics.Subscriber{Registry: registry}); err != nil {
+			log.Fatal(err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", registry)
+		metricsServer = &http.Server{Addr: *metricsListen, Handler: mux}
+		listener, err := net.Listen("tcp", *metricsListen)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			if err := metricsServer.Serve(listener); err != http.ErrServerClosed {
+				log.Println(err)
+			}
+		}()
 	}
 
-	// Initialize all modules and subscribe them on the bus. If a module
-	// cannot be initialized, it is not subscribed on the bus.
-	for _, module := range modules {
-		err = module.Init(configuration)
+	// Serve the control API. A stale socket of an earlier run is removed.
+	var controlServer *http.Server
+	if *controlSocket != "" {
+		alerts := control.NewAlerts(100)
+		if err := h.Subscribe(alerts); err != nil {
+			log.Fatal(err)
+		}
+		if fi, err := os.Lstat(*controlSocket); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(*controlSocket)
+		}
+		listener, err := listenPrivate(*controlSocket)
 		if err != nil {
-			log.Println(err)
-		} else {
-			hub.Subscribe(module)
+			log.Fatal(err)
 		}
+		controlServer = &http.Server{Handler: control.NewHandler(ctl, alerts)}
+		go func() {
+			if err := controlServer.Serve(listener); err != http.ErrServerClosed {
+				log.Println(err)
+			}
+		}()
 	}
 
-	// Create a PacketSource from which we can retrieve packets.
-	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
-	var waitGroup sync.WaitGroup
-	for packet := range packetSource.Packets() {
-		waitGroup.Add(1)
-		go func(waitGroup *sync.WaitGroup) {
-			defer waitGroup.Done()
+	// Reload the configuration on SIGHUP and, if a configuration file is
+	// given, whenever it changes.
+	stopReload := make(chan struct{})
+	reloadStopped := make(chan struct{})
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	var changed <-chan struct{}
+	if *configFile != "" && *watchInterval > 0 {
+		changed = config.Watch(*configFile, *watchInterval, stopReload)
+	}
+	go func() {
+		defer close(reloadStopped)
+		for {
+			select {
+			case <-hup:
+			case <-changed:
+			case <-stopReload:
+				return
+			}
+			ctl.reload()
+		}
+	}()
 
-			if ok := hub.Publish("packet", packet); !ok {
-				fmt.Println("DROP")
-			} else {
-				fmt.Println("FORWARD")
-				forward(handle, packet, fwdIP)
+	// Stop capturing on SIGINT or SIGTERM.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	packets := src.Packets()
+capture:
+	for {
+		select {
+		case packet, ok := <-packets:
+			if !ok {
+				break capture
 			}
-		}(&waitGroup)
+			// The packet is submitted as a whole, so that its
+			// interface reaches the modules.
+			pl.Submit(packet, packet.Verdict)
+		case sig := <-signals:
+			log.Println("Received", sig, "shutting down")
+			break capture
+		}
 	}
 
-	// Wait for threads to finish.
-	waitGroup.Wait()
+	// A second signal aborts the shutdown.
+	signal.Reset(syscall.SIGINT, syscall.SIGTERM)
+
+	// Don't reload or take commands while the modules are being closed.
+	close(stopReload)
+	<-reloadStopped
+	if controlServer != nil {
+		controlServer.Close()
+	}
+	close(stopStats)
+	if metricsServer != nil {
+		metricsServer.Close()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		shutdown(pl, h, active, src, st)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(*shutdownTimeout):
+		log.Println("Shutdown timed out, buffered data may be lost")
+	}
 }
 
-func forward(handle *pcap.Handle, packet gopacket.Packet, fwdIP net.IP) {
-	ipLayer := packet.Layer(layers.LayerTypeIPv4)
-	if ipLayer != nil {
-		if ip, ok := ipLayer.(*layers.IPv4); ok {
-			ip.DstIP = fwdIP
+// shutdown waits for the workers to inspect all in-flight packets and reports
+// the final statistics, then unsubscribes, flushes and closes the modules in
+// the reverse order of their initialization and finally closes the source.
+func shutdown(pl *pipeline.Pipeline, h *hub.Hub, modules []module.Module, src capture.Source, st *statistics) {
+	pl.Close()
+	st.report()
+
+	for i := len(modules) - 1; i >= 0; i-- {
+		m := modules[i]
+		h.Unsubscribe(m)
+		if err := m.Flush(); err != nil {
+			log.Println(err)
+		}
+		if err := m.Close(); err != nil {
+			log.Println(err)
 		}
 	}
-	handle.WritePacketData(packet.Data())
+
+	if err := src.Close(); err != nil {
+		log.Println(err)
+	}
+}
+
+// forward rewrites an accepted packet and injects it again.
+func forward(handle *pcap.Handle, packet gopacket.Packet, rewriter *rewrite.Rewriter) {
+	data, err := rewriter.Rewrite(packet)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err = handle.WritePacketData(data); err != nil {
+		log.Println(err)
+	}
+}
+
+// listenPrivate listens on a Unix socket at path that only the current user
+// can connect to. The socket is created with a restrictive umask, rather than
+// changing its mode afterwards, so there is no moment at which others can.
+func listenPrivate(path string) (net.Listener, error) {
+	mask := syscall.Umask(0077)
+	defer syscall.Umask(mask)
+	return net.Listen("unix", path)
 }
@@ -0,0 +1,276 @@
+// This package exposes the metrics of the IPS in the Prometheus text
+// exposition format, see
+// https://prometheus.io/docs/instrumenting/exposition_formats/. Metrics are
+// either kept by a Registry, as they are reported over the hub, or collected
+// from the rest of the IPS whenever they are scraped.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Hjdskes/ET4397IN/stats"
+)
+
+// Namespace is the prefix of the names of all metrics.
+const Namespace = "et4397in"
+
+// ContentType is the content type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// The types of the metric families.
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// A family is a group of samples sharing a name, type and help text.
+type family struct {
+	name    string
+	typ     string
+	help    string
+	samples map[string]string // The rendered samples, by their labels.
+}
+
+// A Set holds the samples of a single scrape. The first help text and type
+// given for a name are kept.
+type Set struct {
+	families map[string]*family
+}
+
+// NewSet returns an empty Set.
+func NewSet() *Set {
+	return &Set{families: make(map[string]*family)}
+}
+
+func (s *Set) family(name, typ, help string) *family {
+	f, ok := s.families[name]
+	if !ok {
+		f = &family{name: name, typ: typ, help: help, samples: make(map[string]string)}
+		s.families[name] = f
+	}
+	return f
+}
+
+// Counter adds a sample of a counter. The labels are given as pairs of names
+// and values.
+func (s *Set) Counter(name, help string, value float64, labels ...string) {
+	l := render(labels)
+	s.family(name, typeCounter, help).samples[l] = name + l + " " + formatFloat(value)
+}
+
+// Gauge adds a sample of a gauge. The labels are given as pairs of names and
+// values.
+func (s *Set) Gauge(name, help string, value float64, labels ...string) {
+	l := render(labels)
+	s.family(name, typeGauge, help).samples[l] = name + l + " " + formatFloat(value)
+}
+
+// Histogram adds a histogram of durations, in seconds. The labels are given
+// as pairs of names and values.
+func (s *Set) Histogram(name, help string, h stats.HistogramSnapshot, labels ...string) {
+	var b strings.Builder
+	var cumulative uint64
+	for i, bound := range h.Bounds {
+		cumulative += h.Counts[i]
+		le := append(labels[:len(labels):len(labels)], "le", formatFloat(bound.Seconds()))
+		fmt.Fprintf(&b, "%s_bucket%s %d\n", name, render(le), cumulative)
+	}
+	le := append(labels[:len(labels):len(labels)], "le", "+Inf")
+	fmt.Fprintf(&b, "%s_bucket%s %d\n", name, render(le), h.Count)
+	l := render(labels)
+	fmt.Fprintf(&b, "%s_sum%s %s\n", name, l, formatFloat(h.Sum.Seconds()))
+	fmt.Fprintf(&b, "%s_count%s %d", name, l, h.Count)
+	s.family(name, typeHistogram, help).samples[l] = b.String()
+}
+
+// WriteTo writes the samples in the text exposition format, sorted by name and
+// labels so that the output is stable.
+func (s *Set) WriteTo(w io.Writer) (int64, error) {
+	names := make([]string, 0, len(s.families))
+	for name := range s.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		f := s.families[name]
+		fmt.Fprintf(&b, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(&b, "# TYPE %s %s\n", f.name, f.typ)
+		labels := make([]string, 0, len(f.samples))
+		for l := range f.samples {
+			labels = append(labels, l)
+		}
+		sort.Strings(labels)
+		for _, l := range labels {
+			b.WriteString(f.samples[l])
+			b.WriteByte('\n')
+		}
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// Name returns the name of a metric reported by a module over the hub: the
+// namespace, the module and the name, with dashes replaced by underscores.
+// Counters end in _total.
+func Name(module, name string, counter bool) string {
+	n := Namespace + "_" + sanitize(module) + "_" + sanitize(name)
+	if counter && !strings.HasSuffix(n, "_total") {
+		n += "_total"
+	}
+	return n
+}
+
+// sanitize replaces the characters that are not allowed in a metric name by
+// underscores.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// render renders pairs of label names and values, e.g. {module="arp"}. A
+// trailing name without a value is ignored.
+func render(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(sanitize(labels[i]))
+		b.WriteString(`="`)
+		b.WriteString(escapeValue(labels[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	valueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeValue(s string) string {
+	return valueEscaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
+
+// A Collector adds the current values of some metrics to a Set when they are
+// scraped.
+type Collector func(s *Set)
+
+// The Registry struct keeps the metrics that are reported as they change, and
+// the collectors of the others. It is safe for concurrent use.
+type Registry struct {
+	mutex      sync.Mutex
+	values     map[string]*value // By name and labels.
+	collectors []Collector
+}
+
+// A value is a counter or gauge kept by a Registry.
+type value struct {
+	name, help string
+	counter    bool
+	labels     []string
+	value      float64
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{values: make(map[string]*value)}
+}
+
+func (r *Registry) value(name, help string, counter bool, labels []string) *value {
+	key := name + render(labels)
+	v, ok := r.values[key]
+	if !ok {
+		v = &value{name: name, help: help, counter: counter, labels: labels}
+		r.values[key] = v
+	}
+	return v
+}
+
+// Add adds delta to a counter. The labels are given as pairs of names and
+// values.
+func (r *Registry) Add(name, help string, delta float64, labels ...string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.value(name, help, true, labels).value += delta
+}
+
+// Set sets a gauge. The labels are given as pairs of names and values.
+func (r *Registry) Set(name, help string, v float64, labels ...string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.value(name, help, false, labels).value = v
+}
+
+// Register adds a collector that is run on every scrape.
+func (r *Registry) Register(c Collector) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// Gather returns the current values of all metrics.
+func (r *Registry) Gather() *Set {
+	r.mutex.Lock()
+	s := NewSet()
+	for _, v := range r.values {
+		if v.counter {
+			s.Counter(v.name, v.help, v.value, v.labels...)
+		} else {
+			s.Gauge(v.name, v.help, v.value, v.labels...)
+		}
+	}
+	collectors := r.collectors
+	r.mutex.Unlock()
+
+	for _, c := range collectors {
+		c(s)
+	}
+	return s
+}
+
+// ServeHTTP serves the current values of all metrics.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	if _, err := r.Gather().WriteTo(w); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Hjdskes/ET4397IN/hub"
+	"github.com/Hjdskes/ET4397IN/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSet(t *testing.T) {
+	s := NewSet()
+	s.Gauge("test_gauge", "A gauge.", 1.5)
+	s.Counter("test_total", "A counter\nwith a newline.", 2, "module", "arp", "type", `say "hi"`)
+	s.Counter("test_total", "Ignored.", 3, "module", "dos", "type", "syn")
+
+	h := stats.NewHistogram([]time.Duration{time.Millisecond, time.Second})
+	h.Observe(time.Microsecond)
+	h.Observe(500 * time.Millisecond)
+	h.Observe(2 * time.Second)
+	s.Histogram("test_seconds", "A histogram.", h.Snapshot(), "module", "arp")
+
+	var b strings.Builder
+	_, err := s.WriteTo(&b)
+	assert := assert.New(t)
+	assert.Nil(err)
+	assert.Equal(`# HELP test_gauge A gauge.
+# TYPE test_gauge gauge
+test_gauge 1.5
+# HELP test_seconds A histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{module="arp",le="0.001"} 1
+test_seconds_bucket{module="arp",le="1"} 2
+test_seconds_bucket{module="arp",le="+Inf"} 3
+test_seconds_sum{module="arp"} 2.500001
+test_seconds_count{module="arp"} 3
+# HELP test_total A counter\nwith a newline.
+# TYPE test_total counter
+test_total{module="arp",type="say \"hi\""} 2
+test_total{module="dos",type="syn"} 3
+`, b.String())
+}
+
+func TestName(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("et4397in_dos_resets_sent_total", Name("dos", "resets-sent", true))
+	assert.Equal("et4397in_dos_syn_rate", Name("dos", "syn-rate", false))
+	assert.Equal("et4397in_dns_errors_total", Name("dns", "errors-total", true))
+}
+
+func TestEndpoint(t *testing.T) {
+	r := NewRegistry()
+	h := hub.NewHub()
+	assert.Nil(t, h.Subscribe(&Subscriber{Registry: r}))
+	r.Register(func(s *Set) {
+		s.Gauge("et4397in_goroutines", "The number of goroutines.", 7)
+	})
+
+	h.Publish(&hub.MetricEvent{Module: "dos", Name: "resets-sent", Kind: hub.Counter, Value: 1})
+	h.Publish(&hub.MetricEvent{Module: "dos", Name: "resets-sent", Kind: hub.Counter, Value: 1})
+	h.Publish(&hub.MetricEvent{Module: "dos", Name: "syn-rate", Kind: hub.Gauge, Value: 10})
+	h.Publish(&hub.MetricEvent{Module: "dos", Name: "syn-rate", Kind: hub.Gauge, Value: 4})
+	h.Publish(&hub.MetricEvent{Module: "arp", Name: "alerts", Kind: hub.Counter, Value: 1, Labels: []string{"type", "spurious-reply"}})
+	h.Publish(&hub.MetricEvent{Module: "arp", Name: "alerts", Kind: hub.Counter, Value: 1, Labels: []string{"type", "gratuitous"}})
+	h.Publish(&hub.AlertEvent{Module: "arp", Severity: hub.Error})
+
+	server := httptest.NewServer(r)
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/metrics")
+	assert := assert.New(t)
+	assert.Nil(err)
+	defer resp.Body.Close()
+	assert.Equal(ContentType, resp.Header.Get("Content-Type"))
+
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(err)
+	assert.Contains(string(body), "et4397in_dos_resets_sent_total 2\n")
+	assert.Contains(string(body), "# TYPE et4397in_dos_syn_rate gauge\net4397in_dos_syn_rate 4\n")
+	assert.Contains(string(body), `et4397in_alerts_total{module="arp",severity="error"} 1`+"\n")
+	assert.Contains(string(body), "# TYPE et4397in_arp_alerts_total counter\n"+
+		`et4397in_arp_alerts_total{type="gratuitous"} 1`+"\n"+
+		`et4397in_arp_alerts_total{type="spurious-reply"} 1`+"\n")
+	assert.Contains(string(body), "et4397in_goroutines 7\n")
+}
@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"github.com/Hjdskes/ET4397IN/hub"
+)
+
+var (
+	_ hub.AlertHandler  = (*Subscriber)(nil)
+	_ hub.MetricHandler = (*Subscriber)(nil)
+)
+
+// The Subscriber struct keeps the metrics that the modules report over the hub
+// in a Registry, and counts their alerts.
+type Subscriber struct {
+	Registry *Registry
+}
+
+func (s *Subscriber) Topics() []string {
+	return []string{hub.TopicAlert + ".#", hub.TopicMetric + ".#"}
+}
+
+func (s *Subscriber) ReceiveAlert(e *hub.AlertEvent) {
+	s.Registry.Add(Namespace+"_alerts_total", "The number of alerts raised, by module and severity.", 1,
+		"module", e.Module, "severity", e.Severity.String())
+}
+
+func (s *Subscriber) ReceiveMetric(e *hub.MetricEvent) {
+	help := "Reported by the " + e.Module + " module as " + e.Name + "."
+	switch e.Kind {
+	case hub.Counter:
+		s.Registry.Add(Name(e.Module, e.Name, true), help, e.Value, e.Labels...)
+	case hub.Gauge:
+		s.Registry.Set(Name(e.Module, e.Name, false), help, e.Value, e.Labels...)
+	}
+}
@@ -15,20 +15,66 @@ package module
 
 import (
 	"bytes"
-	"fmt"
 	"log"
 	"net"
+	"sync"
 
 	"github.com/Hjdskes/ET4397IN/arp"
 	"github.com/Hjdskes/ET4397IN/config"
 	"github.com/Hjdskes/ET4397IN/hub"
-	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 )
 
+func init() {
+	Register("arp", "Detects spoofed and malformed ARP packets", func(env *Environment) Module {
+		return &ARPModule{Hub: env.Hub}
+	})
+	RegisterRules(arpMalformed, arpUnicastRequest, arpGratuitous, arpBindEthernet,
+		arpBroadcastReply, arpInvalidBinding, arpSpuriousReply)
+	config.Register("arp", func() interface{} {
+		return &ARPConfig{Bindings: make(map[string][]string)}
+	})
+}
+
+// ARPConfig is the configuration section of the ARPModule.
+type ARPConfig struct {
+	// The valid IP-to-MAC bindings: every IPv4 address maps to the MAC
+	// addresses it is allowed to bind to.
+	Bindings map[string][]string `json:"bindings"`
+}
+
+func (c *ARPConfig) Validate() []config.Problem {
+	var ps []config.Problem
+	for ip, macs := range c.Bindings {
+		path := config.Member("bindings", ip)
+		if net.ParseIP(ip).To4() == nil {
+			ps = append(ps, config.Problem{Path: path, Message: "Invalid IPv4 address: " + ip})
+		}
+		if len(macs) == 0 {
+			ps = append(ps, config.Problem{Path: path, Message: "No MAC addresses given"})
+		}
+		for i, s := range macs {
+			if mac, err := net.ParseMAC(s); err != nil || len(mac) != 6 {
+				ps = append(ps, config.Problem{Path: config.Index(path, i), Message: "Invalid MAC address: " + s})
+			}
+		}
+	}
+	return ps
+}
+
+var (
+	_ hub.PacketHandler = (*ARPModule)(nil)
+	_ Reloader          = (*ARPModule)(nil)
+	_ Inspector         = (*ARPModule)(nil)
+)
+
 type ARPModule struct {
 	Hub *hub.Hub
 
+	// Protects the bindings and the seen packets, which are used by all
+	// workers and replaced on a reload.
+	mutex sync.Mutex
+
 	// A map of valid IP-to-MAC allocations, where the IP address is stored
 	// as a string because a byte slice cannot be used as a key, see
 	// http://stackoverflow.com/a/39249045. The MAC address is encoded using
@@ -37,40 +83,94 @@ type ARPModule struct {
 	// address (in e.g. failover setups).
 	validBindings map[string][][]byte
 
-	// A list of seen ARP packets to detect implementation flaws in other
+	// A list of seen ARP requests to detect implementation flaws in other
 	// hosts.
-	seen []*arp.ARP
+	seen []seenRequest
+}
+
+// A seenRequest is an ARP request together with the interface it was seen on;
+// a reply only answers it if it arrives on the same interface.
+type seenRequest struct {
+	*arp.ARP
+	iface string
 }
 
 func (m *ARPModule) Init(config *config.Configuration) error {
-	m.validBindings = make(map[string][][]byte)
+	m.validBindings = parseBindings(config)
+	return nil
+}
+
+// Reload replaces the valid bindings, but remembers the seen requests.
+func (m *ARPModule) Reload(config *config.Configuration) error {
+	bindings := parseBindings(config)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.validBindings = bindings
+	return nil
+}
+
+func parseBindings(c *config.Configuration) map[string][][]byte {
+	bindings := make(map[string][][]byte)
+
+	for s, macs := range c.Section("arp").(*ARPConfig).Bindings {
+		// Store the IP address in the same four byte form as it is
+		// found in ARP packets.
+		ip := net.ParseIP(s).To4()
+		if ip == nil {
+			log.Println("Invalid IPv4 address found in configuration: ", s)
+			continue
+		}
 
-	for ip, macs := range config.ARPBindings {
 		for _, s := range macs {
 			mac, err := net.ParseMAC(s)
 			if err != nil {
 				log.Println("Invalid MAC address found in configuration: ", s)
 			} else {
-				m.validBindings[ip] = append(m.validBindings[ip], mac)
+				bindings[string(ip)] = append(bindings[string(ip)], mac)
 			}
 		}
 	}
 
-	return nil
+	return bindings
+}
+
+// ARPState is the state of the ARPModule.
+type ARPState struct {
+	// The valid IP-to-MAC bindings, as in ARPConfig.
+	Bindings map[string][]string `json:"bindings"`
+	// The number of requests that have not been answered yet.
+	PendingRequests int `json:"pending-requests"`
+}
+
+func (m *ARPModule) State() interface{} {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	state := ARPState{Bindings: make(map[string][]string), PendingRequests: len(m.seen)}
+	for ip, macs := range m.validBindings {
+		for _, mac := range macs {
+			s := net.IP(ip).String()
+			state.Bindings[s] = append(state.Bindings[s], net.HardwareAddr(mac).String())
+		}
+	}
+	return state
 }
 
 func (m *ARPModule) Topics() []string {
 	return []string{"packet"}
 }
 
-func (m *ARPModule) Receive(args []interface{}) bool {
-	packet, ok := args[0].(gopacket.Packet)
-	if !ok {
-		log.Println("ARPModule received data that was not a packet")
-		return true
-	}
+func (m *ARPModule) Flush() error {
+	return nil
+}
 
-	arpLayer := packet.Layer(layers.LayerTypeARP)
+func (m *ARPModule) Close() error {
+	return nil
+}
+
+func (m *ARPModule) ReceivePacket(e *hub.PacketEvent) bool {
+	arpLayer := e.Packet.Layer(layers.LayerTypeARP)
 	if arpLayer == nil {
 		return true
 	}
@@ -78,56 +178,92 @@ func (m *ARPModule) Receive(args []interface{}) bool {
 	data := arpLayer.LayerContents()
 	arp, err := arp.DecodeARP(data)
 	if err != nil {
-		m.Hub.Publish("error", err.Error())
+		m.Hub.Publish(hub.NewAlert(arpMalformed, e, err))
+		count(m.Hub, "arp", "alerts", "type", arpMalformed.Name)
 		return true
 	}
 
-	return m.analyse(arp)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.analyse(e, arp)
 }
 
-const (
-	unicastRequest = "Host %v is unicasting an ARP request to host %v"
-	gratuitous     = "Host %v sent a gratuitous %v"
-	bindEthernet   = "Host %v is trying to bind to the Ethernet broadcast address"
-	broadcastReply = "Host %v is replying to a request from host %v using a broadcast message"
-	invalidBinding = "Host %v is trying to bind to MAC address %v that is not in the list"
-	spuriousReply  = "Host %v is sending a spurious reply"
+// The rules of the ARP module. Their names are also those of the counters of
+// the alerts raised for them.
+var (
+	arpMalformed = &hub.Rule{
+		ID: 1000001, Module: "arp", Name: "malformed", Severity: hub.Warning,
+		Category: "Generic Protocol Command Decode",
+		Template: "Received a malformed ARP packet: %v",
+	}
+	arpUnicastRequest = &hub.Rule{
+		ID: 1000002, Module: "arp", Name: "unicast-request", Severity: hub.Notice,
+		Category: "Misc activity",
+		Template: "Host %v is unicasting an ARP request to host %v",
+	}
+	arpGratuitous = &hub.Rule{
+		ID: 1000003, Module: "arp", Name: "gratuitous", Severity: hub.Notice,
+		Category: "Misc activity",
+		Template: "Host %v sent a gratuitous %v",
+	}
+	arpBindEthernet = &hub.Rule{
+		ID: 1000004, Module: "arp", Name: "bind-ethernet", Severity: hub.Error,
+		Category: "Attempted Denial of Service",
+		Template: "Host %v is trying to bind to the Ethernet broadcast address",
+	}
+	arpBroadcastReply = &hub.Rule{
+		ID: 1000005, Module: "arp", Name: "broadcast-reply", Severity: hub.Notice,
+		Category: "Potentially Bad Traffic",
+		Template: "Host %v is replying to a request from host %v using a broadcast message",
+	}
+	arpInvalidBinding = &hub.Rule{
+		ID: 1000006, Module: "arp", Name: "invalid-binding", Severity: hub.Notice,
+		Category: "Potentially Bad Traffic",
+		Template: "Host %v is trying to bind to MAC address %v that is not in the list",
+	}
+	arpSpuriousReply = &hub.Rule{
+		ID: 1000007, Module: "arp", Name: "spurious-reply", Severity: hub.Notice,
+		Category: "Potentially Bad Traffic",
+		Template: "Host %v is sending a spurious reply",
+	}
 )
 
-func (m *ARPModule) analyse(a *arp.ARP) bool {
+func (m *ARPModule) analyse(e *hub.PacketEvent, a *arp.ARP) bool {
+	src, dst := net.IP(a.SPAddress), net.IP(a.TPAddress)
+
 	switch a.Opcode {
 	case arp.ARPOpcodeRequest:
 		if a.IsGratuitous() {
-			m.Hub.Publish("log", "notice", fmt.Sprintf(gratuitous, a.SPAddress, a.Opcode))
+			m.alert(arpGratuitous, e, a, false, src, a.Opcode)
 		} else if a.IsUnicastRequest() {
-			m.Hub.Publish("log", "notice", fmt.Sprintf(unicastRequest, a.SPAddress, a.TPAddress))
+			m.alert(arpUnicastRequest, e, a, false, src, dst)
 		}
 
 		// Add the request to the remembered list if it isn't
 		// gratuitous.
 		if !a.IsGratuitous() {
-			m.seen = append(m.seen, a)
+			m.seen = append(m.seen, seenRequest{a, e.Interface})
 		}
 	case arp.ARPOpcodeReply:
 		// First check for implementation flaws by means of spurious
 		// replies.
-		if m.isSpurious(a) {
-			m.Hub.Publish("log", "notice", fmt.Sprintf(spuriousReply, a.SPAddress))
+		if m.isSpurious(a, e.Interface) {
+			m.alert(arpSpuriousReply, e, a, true, src)
 			return false
 		}
 
 		// Now we check for malicious ARP replies.
 		if a.IsBindingEthernet() {
-			m.Hub.Publish("log", "error", fmt.Sprintf(bindEthernet, a.SPAddress))
+			m.alert(arpBindEthernet, e, a, true, src)
 			return false
 		} else if a.IsBroadcastReply() {
-			m.Hub.Publish("log", "notice", fmt.Sprintf(broadcastReply, a.SPAddress, a.TPAddress))
+			m.alert(arpBroadcastReply, e, a, true, src, dst)
 			return false
 		} else if a.IsGratuitous() {
-			m.Hub.Publish("log", "notice", fmt.Sprintf(gratuitous, a.SPAddress, a.Opcode))
+			m.alert(arpGratuitous, e, a, true, src, a.Opcode)
 			return false
 		} else if !m.isValidBinding(a) {
-			m.Hub.Publish("log", "notice", fmt.Sprintf(invalidBinding, a.SPAddress, a.SHAddress))
+			m.alert(arpInvalidBinding, e, a, true, src, net.HardwareAddr(a.SHAddress))
 			return false
 		}
 	}
@@ -135,7 +271,19 @@ func (m *ARPModule) analyse(a *arp.ARP) bool {
 	return true
 }
 
-func (m *ARPModule) isSpurious(a *arp.ARP) bool {
+// alert publishes an alert about the sender and target of an ARP packet, of
+// which blocked tells whether the packet is rejected, and counts the alerts of
+// its rule.
+func (m *ARPModule) alert(rule *hub.Rule, e *hub.PacketEvent, a *arp.ARP, blocked bool, args ...interface{}) {
+	alert := hub.NewAlert(rule, e, args...)
+	alert.Source.IP = net.IP(a.SPAddress).String()
+	alert.Destination.IP = net.IP(a.TPAddress).String()
+	alert.Blocked = blocked
+	m.Hub.Publish(alert)
+	count(m.Hub, "arp", "alerts", "type", rule.Name)
+}
+
+func (m *ARPModule) isSpurious(a *arp.ARP, iface string) bool {
 	// A gratuitous reply obviously does not have a matching request in the
 	// remembered list, but it is not a spurious reply.
 	if a.IsGratuitous() {
@@ -145,14 +293,16 @@ func (m *ARPModule) isSpurious(a *arp.ARP) bool {
 	for i, request := range m.seen {
 		// If the target in the current packet is equal to the
 		// sender in the remembered packet and vice versa, this
-		// is a reply to a request we have seen.
-		if bytes.Equal(a.TPAddress, request.SPAddress) &&
+		// is a reply to a request we have seen, provided that it
+		// arrives on the segment the request was seen on.
+		if request.iface == iface &&
+			bytes.Equal(a.TPAddress, request.SPAddress) &&
 			bytes.Equal(a.SPAddress, request.TPAddress) {
 			// When a remembered request has been found, we
 			// know that this reply is not spurious so we remove the
 			// request from the list and return false.
 			copy(m.seen[i:], m.seen[i+1:])
-			m.seen[len(m.seen)-1] = nil
+			m.seen[len(m.seen)-1] = seenRequest{}
 			m.seen = m.seen[:len(m.seen)-1]
 			return false
 		}
@@ -6,11 +6,20 @@ import (
 
 	"github.com/Hjdskes/ET4397IN/config"
 	"github.com/Hjdskes/ET4397IN/dns"
-	"github.com/google/gopacket"
+	"github.com/Hjdskes/ET4397IN/hub"
 	"github.com/google/gopacket/layers"
 )
 
+func init() {
+	Register("dns", "Decodes and prints DNS packets", func(env *Environment) Module {
+		return DNSModule{Hub: env.Hub}
+	})
+}
+
+var _ hub.PacketHandler = DNSModule{}
+
 type DNSModule struct {
+	Hub *hub.Hub
 }
 
 func (m DNSModule) Init(config *config.Configuration) error {
@@ -21,22 +30,26 @@ func (m DNSModule) Topics() []string {
 	return []string{"packet"}
 }
 
-func (m DNSModule) Receive(args []interface{}) bool {
-	packet, ok := args[0].(gopacket.Packet)
-	if !ok {
-		log.Println("DNSModule received data that was not a packet")
-		return true
-	}
+func (m DNSModule) Flush() error {
+	return nil
+}
+
+func (m DNSModule) Close() error {
+	return nil
+}
 
-	dnsLayer := packet.Layer(layers.LayerTypeDNS)
+func (m DNSModule) ReceivePacket(e *hub.PacketEvent) bool {
+	dnsLayer := e.Packet.Layer(layers.LayerTypeDNS)
 	if dnsLayer == nil {
 		return true
 	}
 
 	data := dnsLayer.LayerContents()
 	dns, err := dns.DecodeDNS(data)
+	count(m.Hub, "dns", "packets")
 	if err != nil {
 		log.Println(err)
+		count(m.Hub, "dns", "decode-errors")
 	} else {
 		fmt.Println(dns)
 	}
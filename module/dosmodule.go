@@ -1,50 +1,92 @@
 package module
 
 import (
+	"fmt"
 	"log"
 	"math/rand"
 	"net"
+	"sort"
 	"sync"
 	"time"
 
 	"golang.org/x/net/ipv4"
 
+	"github.com/Hjdskes/ET4397IN/clock"
 	"github.com/Hjdskes/ET4397IN/config"
 	"github.com/Hjdskes/ET4397IN/hub"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 )
 
+func init() {
+	Register("dos", "Rate limits SYN floods by resetting half-open connections", func(env *Environment) Module {
+		return &DoSModule{Hub: env.Hub, Clock: env.Clock, Mutex: &sync.Mutex{}}
+	})
+	config.Register("dos", func() interface{} {
+		return &DoSConfig{
+			SynInterval:  config.Duration{Duration: time.Second},
+			SynThreshold: 1,
+		}
+	})
+}
+
+// DoSConfig is the configuration section of the DoSModule.
+type DoSConfig struct {
+	// Interval after which the current count of SYNs is reset.
+	SynInterval config.Duration `json:"syn-interval"`
+	// Threshold (in packets) which when crossed within the interval
+	// signals a SYN flood attack.
+	SynThreshold int32 `json:"syn-threshold"`
+}
+
+func (c *DoSConfig) Validate() []config.Problem {
+	var ps []config.Problem
+	if c.SynInterval.Duration <= 0 {
+		ps = append(ps, config.Problem{Path: "syn-interval", Message: "Must be positive, found " + c.SynInterval.String()})
+	}
+	if c.SynThreshold < 1 {
+		ps = append(ps, config.Problem{Path: "syn-threshold", Message: fmt.Sprintf("Must be at least 1, found %d", c.SynThreshold)})
+	}
+	return ps
+}
+
+var (
+	_ hub.PacketHandler = (*DoSModule)(nil)
+	_ Reloader          = (*DoSModule)(nil)
+	_ Inspector         = (*DoSModule)(nil)
+)
+
 type DoSModule struct {
 	Hub   *hub.Hub
+	Clock clock.Clock
 	Mutex *sync.Mutex
 
 	cons      map[string]bool // Table tracking all the connected states.
 	threshold int32           // Threshold (in packets) which when crossed within the interval signals an attack.
 	syns      int32           // Amount of SYNs received within the current interval.
-	ticker    *time.Ticker    // The ticker that asynchonously, periodically resets the amount of SYNs.
+	interval  time.Duration   // Interval after which the amount of SYNs is reset.
+	ticker    clock.Ticker    // The ticker that periodically resets the amount of SYNs.
+	random    *rand.Rand      // Decides which packets are let through during a flood.
 	fwdIP     net.IP          // IP to forward packets to.
 	ownIP     net.IP          // IP of the host on which the IPS runs.
 }
 
-func (m *DoSModule) Init(config *config.Configuration) error {
+func (m *DoSModule) Init(c *config.Configuration) error {
+	section := c.Section("dos").(*DoSConfig)
 	m.cons = make(map[string]bool)
-	m.threshold = config.SynThreshold
+	m.threshold = section.SynThreshold
 
 	// Parse and set the forwarding IP address.
-	m.fwdIP = net.ParseIP(config.ForwardIP)
-	if m.fwdIP == nil {
-		log.Fatal("Can't parse forwarding IP address: %s\n", config.ForwardIP)
-	}
-	m.fwdIP = m.fwdIP.To4()
-	if m.fwdIP == nil {
-		log.Fatal("Can't convert forwarding IP address to IPv4: %s\n", config.ForwardIP)
+	var err error
+	m.fwdIP, err = parseForwardIP(c.ForwardIP)
+	if err != nil {
+		return err
 	}
 
 	// Find the first local IP address.
 	addrs, err := net.InterfaceAddrs()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	for _, addr := range addrs {
 		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
@@ -56,36 +98,91 @@ func (m *DoSModule) Init(config *config.Configuration) error {
 		}
 	}
 
-	// Start a ticker that periodically, asynchronously resets the current
-	// SYN count.
-	m.ticker = time.NewTicker(time.Duration(config.SynInterval) * time.Millisecond)
-	go func() {
-		for {
-			select {
-			case <-m.ticker.C:
-				m.Mutex.Lock()
-				m.syns = 0
-				m.Mutex.Unlock()
-			}
-		}
-	}()
+	// Start a ticker that periodically resets the current SYN count, and
+	// reports the rate of SYNs in the interval that ended.
+	m.interval = section.SynInterval.Duration
+	m.ticker = m.Clock.Every(m.interval, func() {
+		m.Mutex.Lock()
+		rate := float64(m.syns) / m.interval.Seconds()
+		m.syns = 0
+		m.Mutex.Unlock()
+		m.Hub.Publish(&hub.MetricEvent{Module: "dos", Name: "syn-rate", Kind: hub.Gauge, Value: rate})
+	})
 
-	// Seed rand which is used to determine if a SYN packet should be forwarded.
-	rand.Seed(time.Now().Unix())
+	// Seed the generator which is used to determine if a SYN packet should
+	// be forwarded. A clock driven by packets reads the zero time before
+	// the first packet, so an analysis of a capture file is repeatable.
+	m.random = rand.New(rand.NewSource(m.Clock.Now().UnixNano()))
 
 	return nil
 }
 
+// Reload changes the threshold, interval and forwarding address, but keeps the
+// table of connected states.
+func (m *DoSModule) Reload(c *config.Configuration) error {
+	section := c.Section("dos").(*DoSConfig)
+	fwdIP, err := parseForwardIP(c.ForwardIP)
+	if err != nil {
+		return err
+	}
+
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+	m.threshold = section.SynThreshold
+	m.fwdIP = fwdIP
+	m.interval = section.SynInterval.Duration
+	m.ticker.Reset(m.interval)
+	return nil
+}
+
+func parseForwardIP(s string) (net.IP, error) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("Can't parse forwarding IP address: %s", s)
+	}
+	ip = ip.To4()
+	if ip == nil {
+		return nil, fmt.Errorf("Can't convert forwarding IP address to IPv4: %s", s)
+	}
+	return ip, nil
+}
+
+// DoSState is the state of the DoSModule.
+type DoSState struct {
+	Threshold int32 `json:"syn-threshold"`
+	// The number of SYNs received within the current interval.
+	SYNs int32 `json:"syns"`
+	// The hosts that completed a handshake, which are never rate limited.
+	Connections []string `json:"connections"`
+}
+
+func (m *DoSModule) State() interface{} {
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+
+	state := DoSState{Threshold: m.threshold, SYNs: m.syns, Connections: []string{}}
+	for ip := range m.cons {
+		state.Connections = append(state.Connections, net.IP(ip).String())
+	}
+	sort.Strings(state.Connections)
+	return state
+}
+
 func (m *DoSModule) Topics() []string {
 	return []string{"packet"}
 }
 
-func (m *DoSModule) Receive(args []interface{}) bool {
-	packet, ok := args[0].(gopacket.Packet)
-	if !ok {
-		log.Println("DoSModule received data that was not a packet")
-		return true
-	}
+func (m *DoSModule) Flush() error {
+	return nil
+}
+
+func (m *DoSModule) Close() error {
+	m.ticker.Stop()
+	return nil
+}
+
+func (m *DoSModule) ReceivePacket(e *hub.PacketEvent) bool {
+	packet := e.Packet
 
 	ipLayer := packet.Layer(layers.LayerTypeIPv4)
 	if ipLayer == nil {
@@ -107,12 +204,14 @@ func (m *DoSModule) Receive(args []interface{}) bool {
 	if tcp.SYN && !tcp.ACK {
 		m.Mutex.Lock()
 		m.syns = m.syns + 1
+		flood := !m.cons[string(ip.SrcIP)] && m.syns > m.threshold
+		lucky := flood && m.random.Intn(100) == 1
 		m.Mutex.Unlock()
 		// If the handshake has not been completed, and the threshold is
 		// crossed within the current interval, we rate limit this
 		// packet by forwarding it with a change 1/100.
-		if !m.cons[string(ip.SrcIP)] && m.syns > m.threshold {
-			if rand.Intn(100) == 1 {
+		if flood {
+			if lucky {
 				return true
 			}
 			m.sendReset(ip, tcp)
@@ -183,5 +282,7 @@ func (m *DoSModule) send(ip *layers.IPv4, tcp *layers.TCP) {
 	err = rawConn.WriteTo(ipHeader, tcpPayloadBuf.Bytes(), nil)
 	if err != nil {
 		log.Println(err)
+		return
 	}
+	count(m.Hub, "dos", "resets-sent")
 }
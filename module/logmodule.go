@@ -3,53 +3,355 @@ package module
 import (
 	"fmt"
 	"log"
+	"os"
+	"strings"
+	"sync"
 
+	"github.com/Hjdskes/ET4397IN/clock"
 	"github.com/Hjdskes/ET4397IN/config"
+	"github.com/Hjdskes/ET4397IN/hub"
+	"github.com/Hjdskes/ET4397IN/sink"
+	"github.com/Hjdskes/ET4397IN/suppress"
 )
 
+func init() {
+	Register("log", "Prints the alerts raised by the other modules", func(env *Environment) Module {
+		return &LogModule{Clock: env.Clock}
+	})
+	config.Register("log", func() interface{} {
+		return &LogConfig{Format: "text", Severity: "notice"}
+	})
+}
+
+// LogConfig is the configuration section of the LogModule.
+type LogConfig struct {
+	// The format of the alerts, see sink.Formats, or empty to write them
+	// to the further sinks only.
+	Format string `json:"format"`
+	// The file to which the alerts are appended, or standard output if
+	// empty.
+	Path string `json:"path"`
+	// Whether EVE records include the packet that triggered the alert.
+	Packet bool `json:"packet"`
+	// The least severe alerts that are written.
+	Severity string `json:"severity"`
+	// Further destinations of the alerts.
+	Sinks []SinkConfig `json:"sinks"`
+
+	// The window within which identical alerts are aggregated, or zero to
+	// write every alert.
+	Aggregate config.Duration `json:"aggregate"`
+	// The maximum rates at which the alerts of a rule are written.
+	RateLimits []RateLimitConfig `json:"rate-limits"`
+	// The alerts that are not written at all.
+	Suppress []SuppressConfig `json:"suppress"`
+}
+
+// RateLimitConfig limits the alerts of a rule that are written.
+type RateLimitConfig struct {
+	// The id of the rule, or zero for every rule without a limit of its
+	// own.
+	Rule     int             `json:"rule,omitempty"`
+	Alerts   int             `json:"alerts"`
+	Interval config.Duration `json:"interval"`
+}
+
+// SuppressConfig selects alerts that are not written. Empty values match any
+// alert.
+type SuppressConfig struct {
+	Rule int `json:"rule,omitempty"`
+	// A MAC address, an IP address or a network in CIDR notation.
+	Source      string `json:"source,omitempty"`
+	Destination string `json:"destination,omitempty"`
+}
+
+// SinkTypes are the valid values of SinkConfig.Type.
+var SinkTypes = []string{"file", "syslog"}
+
+// SinkConfig configures a further destination of the alerts. Empty values are
+// replaced by the defaults given below.
+type SinkConfig struct {
+	// Either "file" or "syslog".
+	Type string `json:"type"`
+	// The format of the alerts, "text" by default.
+	Format string `json:"format,omitempty"`
+	// Whether EVE records include the packet that triggered the alert.
+	Packet bool `json:"packet,omitempty"`
+	// The least severe alerts that are written, "notice" by default.
+	Severity string `json:"severity,omitempty"`
+
+	// The file to which the alerts are appended, its size in bytes beyond
+	// which it is rotated, if positive, and the number of rotated files
+	// that are kept.
+	Path     string `json:"path,omitempty"`
+	MaxSize  int64  `json:"max-size,omitempty"`
+	MaxFiles int    `json:"max-files,omitempty"`
+
+	// The transport and address of the syslog server, "udp" by default,
+	// and the facility of the messages, "local0" by default.
+	Network  string `json:"network,omitempty"`
+	Address  string `json:"address,omitempty"`
+	Facility string `json:"facility,omitempty"`
+}
+
+func orDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *LogConfig) Validate() []config.Problem {
+	var ps []config.Problem
+	if _, err := sink.NewFormat(c.Format, c.Packet); c.Format != "" && err != nil {
+		ps = append(ps, config.Problem{Path: "format", Message: err.Error()})
+	}
+	if _, err := hub.ParseSeverity(c.Severity); err != nil {
+		ps = append(ps, config.Problem{Path: "severity", Message: err.Error()})
+	}
+
+	for i, s := range c.Sinks {
+		path := config.Index("sinks", i)
+		problem := func(key, format string, args ...interface{}) {
+			ps = append(ps, config.Problem{Path: config.Key(path, key), Message: fmt.Sprintf(format, args...)})
+		}
+		if _, err := sink.NewFormat(orDefault(s.Format, "text"), s.Packet); err != nil {
+			problem("format", "%v", err)
+		}
+		if _, err := hub.ParseSeverity(orDefault(s.Severity, "notice")); err != nil {
+			problem("severity", "%v", err)
+		}
+
+		switch s.Type {
+		case "file":
+			if s.Path == "" {
+				problem("path", "A file sink needs a path")
+			}
+			if s.MaxSize < 0 {
+				problem("max-size", "Must not be negative, found %d", s.MaxSize)
+			}
+			if s.MaxFiles < 0 {
+				problem("max-files", "Must not be negative, found %d", s.MaxFiles)
+			}
+		case "syslog":
+			if s.Address == "" {
+				problem("address", "A syslog sink needs an address")
+			}
+			if network := orDefault(s.Network, "udp"); !contains(sink.Networks, network) {
+				problem("network", "Invalid network %q, must be one of %s", network, strings.Join(sink.Networks, ", "))
+			}
+			if _, ok := sink.Facilities[orDefault(s.Facility, "local0")]; !ok {
+				problem("facility", "Invalid facility %q, must be one of %s", s.Facility, strings.Join(sink.FacilityNames(), ", "))
+			}
+		default:
+			problem("type", "Invalid sink type %q, must be one of %s", s.Type, strings.Join(SinkTypes, ", "))
+		}
+	}
+
+	if c.Aggregate.Duration < 0 {
+		ps = append(ps, config.Problem{Path: "aggregate", Message: "Must not be negative, found " + c.Aggregate.String()})
+	}
+	for i, l := range c.RateLimits {
+		path := config.Index("rate-limits", i)
+		if l.Rule != 0 && LookupRule(l.Rule) == nil {
+			ps = append(ps, config.Problem{Path: config.Key(path, "rule"), Message: fmt.Sprintf("Unknown rule: %d", l.Rule)})
+		}
+		if l.Alerts < 1 {
+			ps = append(ps, config.Problem{Path: config.Key(path, "alerts"), Message: fmt.Sprintf("Must be at least 1, found %d", l.Alerts)})
+		}
+		if l.Interval.Duration <= 0 {
+			ps = append(ps, config.Problem{Path: config.Key(path, "interval"), Message: "Must be positive, found " + l.Interval.String()})
+		}
+	}
+	for i, f := range c.Suppress {
+		path := config.Index("suppress", i)
+		if f.Rule != 0 && LookupRule(f.Rule) == nil {
+			ps = append(ps, config.Problem{Path: config.Key(path, "rule"), Message: fmt.Sprintf("Unknown rule: %d", f.Rule)})
+		}
+		if _, err := suppress.ParseAddress(f.Source); err != nil {
+			ps = append(ps, config.Problem{Path: config.Key(path, "source"), Message: err.Error()})
+		}
+		if _, err := suppress.ParseAddress(f.Destination); err != nil {
+			ps = append(ps, config.Problem{Path: config.Key(path, "destination"), Message: err.Error()})
+		}
+	}
+	return ps
+}
+
+// suppression returns the rate limits and filters of a configuration, of
+// which the addresses must be valid.
+func (c *LogConfig) suppression() ([]suppress.Limit, []suppress.Filter) {
+	var limits []suppress.Limit
+	for _, l := range c.RateLimits {
+		limits = append(limits, suppress.Limit{Rule: l.Rule, Alerts: l.Alerts, Interval: l.Interval.Duration})
+	}
+	var filters []suppress.Filter
+	for _, f := range c.Suppress {
+		source, _ := suppress.ParseAddress(f.Source)
+		destination, _ := suppress.ParseAddress(f.Destination)
+		filters = append(filters, suppress.Filter{Rule: f.Rule, Source: source, Destination: destination})
+	}
+	return limits, filters
+}
+
+var (
+	_ hub.AlertHandler = (*LogModule)(nil)
+	_ Reloader         = (*LogModule)(nil)
+)
+
+// The LogModule writes the alerts raised by the other modules to its sinks,
+// unless they are suppressed.
 type LogModule struct {
+	Clock clock.Clock
+
+	suppressor *suppress.Suppressor
+
+	// Protects the sinks, which are replaced on a reload.
+	mutex sync.RWMutex
+	sinks []output
+}
+
+// An output is a sink together with the least severe alerts written to it.
+type output struct {
+	sink.Sink
+	severity hub.Severity
 }
 
-func (m LogModule) Init(config *config.Configuration) error {
+func (m *LogModule) Init(config *config.Configuration) error {
+	section := config.Section("log").(*LogConfig)
+	sinks, err := openSinks(section)
+	if err != nil {
+		return err
+	}
+	m.sinks = sinks
+
+	limits, filters := section.suppression()
+	m.suppressor = suppress.New(m.Clock, m.write, section.Aggregate.Duration, limits, filters)
 	return nil
 }
 
-func (m LogModule) Topics() []string {
-	return []string{"log"}
+// Reload opens the sinks in the configuration and closes the previous ones.
+// Files are reopened even if their paths did not change, so that they can be
+// rotated by moving them away and reloading. The alerts that are being
+// aggregated or rate limited are kept.
+func (m *LogModule) Reload(config *config.Configuration) error {
+	section := config.Section("log").(*LogConfig)
+	sinks, err := openSinks(section)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	previous := m.sinks
+	m.sinks = sinks
+	m.mutex.Unlock()
+
+	limits, filters := section.suppression()
+	m.suppressor.Configure(section.Aggregate.Duration, limits, filters)
+	return closeSinks(previous)
 }
 
-func (m LogModule) Receive(args []interface{}) bool {
-	if len(args) != 2 {
-		log.Println("LogModule needs a category and a message")
-		return true
+// openSinks opens the output, unless its format is empty, and the further
+// sinks of a configuration. If one of them fails, the others are closed again.
+func openSinks(c *LogConfig) ([]output, error) {
+	sinks := c.Sinks
+	if c.Format != "" {
+		primary := SinkConfig{Type: "stdout", Format: c.Format, Packet: c.Packet, Severity: c.Severity, Path: c.Path}
+		if primary.Path != "" {
+			primary.Type = "file"
+		}
+		sinks = append([]SinkConfig{primary}, sinks...)
 	}
 
-	cat, ok := args[0].(string)
-	if !ok {
-		log.Println("LogModule category should be a string, defaulting to notice")
-		cat = "notice"
+	var outputs []output
+	for _, s := range sinks {
+		o, err := openSink(s)
+		if err != nil {
+			closeSinks(outputs)
+			return nil, err
+		}
+		outputs = append(outputs, o)
 	}
+	return outputs, nil
+}
 
-	msg, ok := args[1].(string)
-	if !ok {
-		log.Println("LogModule message should be a string, aborting")
-		return true
+func openSink(c SinkConfig) (output, error) {
+	o := output{}
+	format, err := sink.NewFormat(orDefault(c.Format, "text"), c.Packet)
+	if err != nil {
+		return o, err
+	}
+	if o.severity, err = hub.ParseSeverity(orDefault(c.Severity, "notice")); err != nil {
+		return o, err
 	}
 
-	switch cat {
-	case "notice":
-		m.logNotice(msg)
-	case "error":
-		m.logError(msg)
+	switch c.Type {
+	case "stdout":
+		o.Sink = sink.NewWriter(os.Stdout, format)
+	case "file":
+		o.Sink, err = sink.NewFile(c.Path, c.MaxSize, c.MaxFiles, format)
+	case "syslog":
+		o.Sink, err = sink.NewSyslog(orDefault(c.Network, "udp"), c.Address, orDefault(c.Facility, "local0"), format)
+	default:
+		err = fmt.Errorf("Invalid sink type %q", c.Type)
 	}
+	return o, err
+}
+
+func closeSinks(outputs []output) error {
+	var err error
+	for _, o := range outputs {
+		if cerr := o.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
 
-	return true
+func (m *LogModule) Topics() []string {
+	return []string{"alert.#"}
 }
 
-func (m LogModule) logNotice(msg string) {
-	fmt.Println("WARNING: ", msg)
+// Flush writes the summaries of the alerts that are being aggregated or rate
+// limited.
+func (m *LogModule) Flush() error {
+	m.suppressor.Flush()
+	return nil
+}
+
+func (m *LogModule) Close() error {
+	m.suppressor.Stop()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	err := closeSinks(m.sinks)
+	m.sinks = nil
+	return err
 }
 
-func (m LogModule) logError(msg string) {
-	fmt.Println("ERROR: ", msg)
+func (m *LogModule) ReceiveAlert(e *hub.AlertEvent) {
+	m.suppressor.Receive(e)
+}
+
+// write writes an alert to the sinks of its severity.
+func (m *LogModule) write(e *hub.AlertEvent) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for _, o := range m.sinks {
+		if e.Severity < o.severity {
+			continue
+		}
+		if err := o.Write(e); err != nil {
+			log.Println(err)
+		}
+	}
 }
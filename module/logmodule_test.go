@@ -0,0 +1,82 @@
+package module
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Hjdskes/ET4397IN/clock"
+	"github.com/Hjdskes/ET4397IN/config"
+	"github.com/Hjdskes/ET4397IN/hub"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogSinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	errors, all := filepath.Join(dir, "errors.log"), filepath.Join(dir, "all.log")
+
+	c, err := config.New("",
+		"log.path="+errors,
+		"log.severity=error",
+		`log.sinks=[{"type": "file", "path": "`+all+`"}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert := assert.New(t)
+	m := &LogModule{Clock: clock.Wall()}
+	assert.Nil(m.Init(c))
+	m.ReceiveAlert(&hub.AlertEvent{Severity: hub.Notice, Message: "Noticed"})
+	m.ReceiveAlert(&hub.AlertEvent{Severity: hub.Error, Message: "Broken"})
+	assert.Nil(m.Close())
+
+	read := func(name string) string {
+		data, _ := ioutil.ReadFile(name)
+		return string(data)
+	}
+	assert.Equal("ERROR: Broken\n", read(errors))
+	assert.Equal("NOTICE: Noticed\nERROR: Broken\n", read(all))
+}
+
+func TestLogWithoutOutput(t *testing.T) {
+	c, err := config.New("", "log.format=", `log.sinks=[{"type": "syslog", "address": "localhost:514"}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the further sinks are opened.
+	assert := assert.New(t)
+	sinks, err := openSinks(c.Section("log").(*LogConfig))
+	assert.Nil(err)
+	assert.Len(sinks, 1)
+	assert.Nil(closeSinks(sinks))
+}
+
+func TestLogConfig(t *testing.T) {
+	c := &LogConfig{Format: "json", Severity: "notice", Sinks: []SinkConfig{
+		{Type: "file"},
+		{Type: "syslog", Address: "localhost:514", Network: "sctp", Severity: "critical"},
+		{Type: "pager"},
+	}}
+	c.RateLimits = []RateLimitConfig{{Rule: 1000003, Alerts: 10, Interval: config.Duration{Duration: time.Minute}}, {Rule: 42}}
+	c.Suppress = []SuppressConfig{{Rule: 1000101, Source: "10.0.0.0/8"}, {Destination: "nowhere"}}
+
+	assert := assert.New(t)
+	assert.Equal([]config.Problem{
+		{Path: "format", Message: `Invalid format "json", must be one of text, eve, cef`},
+		{Path: "sinks[0].path", Message: "A file sink needs a path"},
+		{Path: "sinks[1].severity", Message: `Invalid severity "critical", must be one of notice, warning, error`},
+		{Path: "sinks[1].network", Message: `Invalid network "sctp", must be one of udp, tcp, unix`},
+		{Path: "sinks[2].type", Message: `Invalid sink type "pager", must be one of file, syslog`},
+		{Path: "rate-limits[1].rule", Message: "Unknown rule: 42"},
+		{Path: "rate-limits[1].alerts", Message: "Must be at least 1, found 0"},
+		{Path: "rate-limits[1].interval", Message: "Must be positive, found 0s"},
+		{Path: "suppress[1].destination", Message: "Invalid address: nowhere"},
+	}, c.Validate())
+}
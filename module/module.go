@@ -6,16 +6,101 @@ import (
 )
 
 // A module is a piece of code performing one task of the Intrusion Prevention
-// System.  It receives its packets over the message bus (see Hub) and is in
+// System.  It receives its events over the message bus (see Hub) and is in
 // part a Subscriber.
 //
+// The lifetime of a module starts with Init, after which it is subscribed on
+// the bus. When the IPS shuts down, the module is unsubscribed, after which
+// Flush and then Close are called. Close must stop any goroutines started by
+// the module and release its resources; the module is not used afterwards.
+//
 // Any module wishing to receive packets from the network interface card or a
-// dumped file, should subscribe to the topic "packet". A message under this
-// topic is a single gopacket.Packet.
+// dumped file, should subscribe to the topic "packet" and implement
+// hub.PacketHandler. Any module wishing to report a condition it detected
+// should publish a hub.AlertEvent.
 type Module interface {
 	hub.Subscriber
 
 	// Init can be implemented to initialize the module. See
 	// config.Configuration.
 	Init(config *config.Configuration) error
+
+	// Flush writes out any data the module has buffered.
+	Flush() error
+
+	// Close stops the module and releases its resources.
+	Close() error
+}
+
+// A LegacyModule is a module written against the untyped message bus, see
+// hub.LegacySubscriber.
+type LegacyModule interface {
+	hub.LegacySubscriber
+
+	Init(config *config.Configuration) error
+}
+
+// Adapt turns a LegacyModule into a Module that receives typed events. Legacy
+// modules have nothing to flush or close.
+func Adapt(m LegacyModule) Module {
+	return legacyModule{hub.Legacy(m), m}
+}
+
+type legacyModule struct {
+	hub.Adapter
+	m LegacyModule
+}
+
+func (l legacyModule) Init(config *config.Configuration) error {
+	return l.m.Init(config)
+}
+
+func (l legacyModule) Flush() error {
+	return nil
+}
+
+func (l legacyModule) Close() error {
+	return nil
+}
+
+// An Inspector is a module that can describe its current state, for instance
+// to the control API. The state is encoded as JSON.
+type Inspector interface {
+	State() interface{}
+}
+
+// count publishes a MetricEvent increasing a counter of a module by one. The
+// labels are given as pairs of names and values.
+func count(h *hub.Hub, module, name string, labels ...string) {
+	h.Publish(&hub.MetricEvent{Module: module, Name: name, Kind: hub.Counter, Value: 1, Labels: labels})
+}
+
+// A Reloader is a module that can take over a changed configuration while the
+// IPS is running, without losing the state it has built up.
+type Reloader interface {
+	// Reload applies the configuration. If it returns an error, the
+	// module must still be using its previous configuration.
+	Reload(config *config.Configuration) error
+}
+
+// Reload hands a changed configuration to all modules implementing Reloader.
+// If one of them fails, the modules that already reloaded are handed the
+// previous configuration again and the error is returned.
+func Reload(modules []Module, previous, config *config.Configuration) error {
+	for i, m := range modules {
+		r, ok := m.(Reloader)
+		if !ok {
+			continue
+		}
+
+		if err := r.Reload(config); err != nil {
+			for _, m := range modules[:i] {
+				if r, ok := m.(Reloader); ok {
+					r.Reload(previous)
+				}
+			}
+			return err
+		}
+	}
+	return nil
 }
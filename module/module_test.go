@@ -0,0 +1,55 @@
+package module
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Hjdskes/ET4397IN/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type testReloader struct {
+	LogModule
+	fail   bool
+	config *config.Configuration
+}
+
+func (m *testReloader) Reload(config *config.Configuration) error {
+	if m.fail {
+		return errors.New("reload failed")
+	}
+	m.config = config
+	return nil
+}
+
+func TestReload(t *testing.T) {
+	previous, next := &config.Configuration{}, &config.Configuration{}
+	first, second := &testReloader{config: previous}, &testReloader{config: previous}
+
+	assert := assert.New(t)
+	assert.Nil(Reload([]Module{first, &WriteModule{}, second}, previous, next))
+	assert.True(next == first.config)
+	assert.True(next == second.config)
+}
+
+func TestReloadRollsBack(t *testing.T) {
+	previous, next := &config.Configuration{}, &config.Configuration{}
+	first, second := &testReloader{config: previous}, &testReloader{config: previous, fail: true}
+
+	assert := assert.New(t)
+	assert.EqualError(Reload([]Module{first, second}, previous, next), "reload failed")
+	assert.True(previous == first.config)
+	assert.True(previous == second.config)
+}
+
+func TestExampleConfiguration(t *testing.T) {
+	c, err := config.New("../config.json")
+
+	assert := assert.New(t)
+	assert.Nil(err)
+	assert.Equal(int32(2), c.Section("dos").(*DoSConfig).SynThreshold)
+	assert.Equal(time.Second, c.Section("wifi").(*WiFiConfig).Interval.Duration)
+	assert.Equal([]string{"aa:aa:aa:aa:aa:aa", "bb:bb:bb:bb:bb:bb"},
+		c.Section("arp").(*ARPConfig).Bindings["192.168.0.2"])
+}
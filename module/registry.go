@@ -0,0 +1,179 @@
+package module
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Hjdskes/ET4397IN/clock"
+	"github.com/Hjdskes/ET4397IN/hub"
+	"github.com/google/gopacket/layers"
+)
+
+// An Environment holds everything a module may need from the rest of the IPS
+// when it is created.
+type Environment struct {
+	Hub      *hub.Hub
+	Clock    clock.Clock     // The clock to tell the time by.
+	Path     string          // Path of the file to save packets into, if any.
+	Snaplen  int             // Maximum size of the captured packets.
+	LinkType layers.LinkType // Type of the first layer of the captured packets.
+}
+
+// A Factory creates a new, uninitialized instance of a module.
+type Factory func(env *Environment) Module
+
+type registration struct {
+	description string
+	factory     Factory
+}
+
+// The registry of all available modules, by name. Modules register
+// themselves from an init function, so it is not modified afterwards.
+var registry = make(map[string]registration)
+
+// Register makes a module available under the given name. It panics if a
+// module is registered twice under the same name.
+func Register(name, description string, factory Factory) {
+	if _, ok := registry[name]; ok {
+		panic("module: Register called twice for module " + name)
+	}
+	registry[name] = registration{description, factory}
+}
+
+// Names returns the names of all registered modules in alphabetical order.
+func Names() []string {
+	var names []string
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Describe returns the description of a registered module.
+func Describe(name string) string {
+	return registry[name].description
+}
+
+// New creates the module registered under the given name.
+func New(name string, env *Environment) (Module, error) {
+	r, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown module: %s", name)
+	}
+	return r.factory(env), nil
+}
+
+// The rules of the modules, by id. Like the modules, they are registered from
+// init functions.
+var rules = make(map[int]*hub.Rule)
+
+// RegisterRules makes the rules of a module known, so that the configuration
+// can refer to them by id. It panics if two rules have the same id.
+func RegisterRules(rs ...*hub.Rule) {
+	for _, r := range rs {
+		if _, ok := rules[r.ID]; ok {
+			panic("module: RegisterRules called twice for rule " + strconv.Itoa(r.ID))
+		}
+		rules[r.ID] = r
+	}
+}
+
+// LookupRule returns the registered rule with the given id, or nil.
+func LookupRule(id int) *hub.Rule {
+	return rules[id]
+}
+
+// A Selection is a module chosen to run, together with the weight of its
+// verdicts under the hub.Weighted policy.
+type Selection struct {
+	Name   string
+	Weight int
+}
+
+// Select returns the modules to run, in order. Every entry has the form
+// name[:weight], where the weight defaults to 1.
+//
+// The base list usually comes from the configuration, and spec from the
+// command line as a comma separated list. If spec is empty, the base list is
+// used. If every entry in spec starts with a + or a -, the named modules are
+// respectively appended to or removed from the base list; otherwise spec
+// replaces the base list.
+func Select(base []string, spec string) ([]Selection, error) {
+	var selection []Selection
+	for _, entry := range base {
+		s, err := parseSelection(entry)
+		if err != nil {
+			return nil, err
+		}
+		selection = append(selection, s)
+	}
+
+	if spec == "" {
+		return selection, nil
+	}
+
+	var entries []string
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+
+	modify := true
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry, "+") && !strings.HasPrefix(entry, "-") {
+			modify = false
+		}
+	}
+	if !modify {
+		selection = nil
+	}
+
+	for _, entry := range entries {
+		op := entry[:1]
+		if modify {
+			entry = entry[1:]
+		}
+		s, err := parseSelection(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		if modify && op == "-" {
+			selection = without(selection, s.Name)
+		} else {
+			selection = append(without(selection, s.Name), s)
+		}
+	}
+	return selection, nil
+}
+
+func parseSelection(entry string) (Selection, error) {
+	s := Selection{Name: entry, Weight: 1}
+	if i := strings.Index(entry, ":"); i >= 0 {
+		weight, err := strconv.Atoi(entry[i+1:])
+		if err != nil || weight < 0 {
+			return s, fmt.Errorf("Invalid weight for module %s: %s", entry[:i], entry[i+1:])
+		}
+		s.Name, s.Weight = entry[:i], weight
+	}
+
+	if _, ok := registry[s.Name]; !ok {
+		return s, fmt.Errorf("Unknown module: %s", s.Name)
+	}
+	return s, nil
+}
+
+// without returns the selection without the named module.
+func without(selection []Selection, name string) []Selection {
+	var result []Selection
+	for _, s := range selection {
+		if s.Name != name {
+			result = append(result, s)
+		}
+	}
+	return result
+}
@@ -0,0 +1,43 @@
+package module
+
+import (
+	"testing"
+
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelect(t *testing.T) {
+	base := []string{"dos", "log"}
+	assert := assert.New(t)
+
+	selection, err := Select(base, "")
+	assert.Nil(err)
+	assert.Equal([]Selection{{"dos", 1}, {"log", 1}}, selection)
+
+	selection, err = Select(base, "arp:2,log")
+	assert.Nil(err)
+	assert.Equal([]Selection{{"arp", 2}, {"log", 1}}, selection)
+
+	selection, err = Select(base, "-dos,+arp,+wifi:3")
+	assert.Nil(err)
+	assert.Equal([]Selection{{"log", 1}, {"arp", 1}, {"wifi", 3}}, selection)
+
+	_, err = Select(base, "+ids")
+	assert.EqualError(err, "Unknown module: ids")
+
+	_, err = Select([]string{"arp:heavy"}, "")
+	assert.EqualError(err, "Invalid weight for module arp: heavy")
+}
+
+func TestNew(t *testing.T) {
+	env := &Environment{Path: "packets.pcap", Snaplen: 1500, LinkType: layers.LinkTypeRaw}
+	m, err := New("write", env)
+
+	assert := assert.New(t)
+	assert.Nil(err)
+	assert.Equal(&WriteModule{Path: "packets.pcap", Snaplen: 1500, LinkType: layers.LinkTypeRaw}, m)
+
+	_, err = New("ids", env)
+	assert.EqualError(err, "Unknown module: ids")
+}
@@ -2,10 +2,10 @@ package module
 
 import (
 	"bytes"
-	"fmt"
-	"log"
+	"sync"
 	"time"
 
+	"github.com/Hjdskes/ET4397IN/clock"
 	"github.com/Hjdskes/ET4397IN/config"
 	"github.com/Hjdskes/ET4397IN/hub"
 	"github.com/Hjdskes/ET4397IN/util"
@@ -13,12 +13,47 @@ import (
 	"github.com/google/gopacket/layers"
 )
 
+func init() {
+	Register("wifi", "Detects deauthentication and ARP replay attacks on 802.11", func(env *Environment) Module {
+		return &WiFiModule{Hub: env.Hub, Clock: env.Clock}
+	})
+	RegisterRules(wifiDeauth, wifiReplay)
+	config.Register("wifi", func() interface{} {
+		return &WiFiConfig{Interval: config.Duration{Duration: time.Second}}
+	})
+}
+
+// WiFiConfig is the configuration section of the WiFiModule.
+type WiFiConfig struct {
+	// Interval within which two received packets are suspected to be an
+	// attack.
+	Interval config.Duration `json:"interval"`
+}
+
+func (c *WiFiConfig) Validate() []config.Problem {
+	if c.Interval.Duration <= 0 {
+		return []config.Problem{{Path: "interval", Message: "Must be positive, found " + c.Interval.String()}}
+	}
+	return nil
+}
+
+var (
+	_ hub.PacketHandler = (*WiFiModule)(nil)
+	_ Reloader          = (*WiFiModule)(nil)
+	_ Inspector         = (*WiFiModule)(nil)
+)
+
 type WiFiModule struct {
-	Hub *hub.Hub
+	Hub   *hub.Hub
+	Clock clock.Clock
 
-	// Interval (in nanoseconds) within which two received packets are
-	// suspected to be an attack.
-	interval int64
+	// Protects the fields below, which are used by all workers and changed
+	// on a reload.
+	mutex sync.Mutex
+
+	// Interval within which two received packets are suspected to be an
+	// attack.
+	interval time.Duration
 
 	// Time at which the last deauthentication or disassociation frame was
 	// received. Used to check if the current frame is sent within the
@@ -33,67 +68,105 @@ type WiFiModule struct {
 	weps *util.Queue
 }
 
-func (m *WiFiModule) Init(config *config.Configuration) error {
-	m.interval = config.Interval
+func (m *WiFiModule) Init(c *config.Configuration) error {
+	m.interval = c.Section("wifi").(*WiFiConfig).Interval.Duration
 	m.weps = util.NewQueue()
 	return nil
 }
 
+// Reload changes the interval, but remembers the previous frames.
+func (m *WiFiModule) Reload(c *config.Configuration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.interval = c.Section("wifi").(*WiFiConfig).Interval.Duration
+	return nil
+}
+
+// WiFiState is the state of the WiFiModule.
+type WiFiState struct {
+	Interval       config.Duration `json:"interval"`
+	PrevDeauthTime time.Time       `json:"last-deauth"`
+	PrevWEPTime    time.Time       `json:"last-wep"`
+}
+
+func (m *WiFiModule) State() interface{} {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return WiFiState{config.Duration{Duration: m.interval}, m.prevDeauthTime, m.prevWEPTime}
+}
+
 func (m *WiFiModule) Topics() []string {
 	return []string{"packet"}
 }
 
-const (
-	deauth = "Host %v is possibly performing a disassociation or deauthentication attack"
-	replay = "Host %v is possibly performing an ARP replay attack"
-)
+func (m *WiFiModule) Flush() error {
+	return nil
+}
 
-func (m *WiFiModule) Receive(args []interface{}) bool {
-	cur := time.Now()
+func (m *WiFiModule) Close() error {
+	return nil
+}
 
-	packet, ok := args[0].(gopacket.Packet)
-	if !ok {
-		log.Println("WiFiModule received data that was not a packet")
-		return true
+// The rules of the WiFi module.
+var (
+	wifiDeauth = &hub.Rule{
+		ID: 1000101, Module: "wifi", Name: "deauth", Severity: hub.Notice,
+		Category: "Attempted Denial of Service",
+		Template: "Host %v is possibly performing a disassociation or deauthentication attack",
+	}
+	wifiReplay = &hub.Rule{
+		ID: 1000102, Module: "wifi", Name: "replay", Severity: hub.Notice,
+		Category: "Potentially Bad Traffic",
+		Template: "Host %v is possibly performing an ARP replay attack",
 	}
+)
+
+func (m *WiFiModule) ReceivePacket(e *hub.PacketEvent) bool {
+	cur := m.Clock.Now()
+	packet := e.Packet
 
 	dot11Layer := packet.Layer(layers.LayerTypeDot11)
 	if dot11Layer == nil {
 		return true
 	}
 
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	dot11 := &layers.Dot11{}
 	data := dot11Layer.LayerPayload()
 	dot11.DecodeFromBytes(data, gopacket.NilDecodeFeedback)
 
 	switch dot11.Type {
 	case layers.Dot11TypeMgmtDisassociation, layers.Dot11TypeMgmtDeauthentication:
-		return m.deauth(dot11, cur)
+		return m.deauth(e, dot11, cur)
 	case layers.Dot11TypeData:
 		if dot11.Flags.WEP() {
 			contents := packet.Layer(layers.LayerTypeDot11WEP).LayerContents()
-			return m.arpReplay(dot11, contents, cur)
+			return m.arpReplay(e, dot11, contents, cur)
 		}
 	}
 
 	return true
 }
 
-func (m *WiFiModule) deauth(dot11 *layers.Dot11, cur time.Time) bool {
+func (m *WiFiModule) deauth(e *hub.PacketEvent, dot11 *layers.Dot11, cur time.Time) bool {
 	// If this disassociation or deauthentication frame is sent within the
 	// interval, we notice this as a possible attack.
-	if cur.Sub(m.prevDeauthTime)*time.Nanosecond < time.Duration(m.interval) {
-		m.Hub.Publish("log", "notice", fmt.Sprintf(deauth, dot11.Address1))
+	count(m.Hub, "wifi", "deauth-frames")
+	if cur.Sub(m.prevDeauthTime) < m.interval {
+		m.alert(wifiDeauth, e, dot11)
+		count(m.Hub, "wifi", "deauth-attacks")
 	}
 	m.prevDeauthTime = cur
 	return true
 }
 
-func (m *WiFiModule) arpReplay(dot11 *layers.Dot11, data []byte, cur time.Time) bool {
+func (m *WiFiModule) arpReplay(e *hub.PacketEvent, dot11 *layers.Dot11, data []byte, cur time.Time) bool {
 	// If this WEP packet is sent within the interval and the contents match
 	// the contents of one of the last 10 receives packets, we notice this
 	// as a possible attack.
-	if cur.Sub(m.prevWEPTime)*time.Nanosecond < time.Duration(m.interval) {
+	if cur.Sub(m.prevWEPTime) < m.interval {
 		m.weps.ForEach(func(item interface{}) bool {
 			wep, ok := item.([]byte)
 			if !ok {
@@ -101,7 +174,8 @@ func (m *WiFiModule) arpReplay(dot11 *layers.Dot11, data []byte, cur time.Time)
 			}
 
 			if bytes.Equal(wep, data) {
-				m.Hub.Publish("log", "notice", fmt.Sprintf(replay, dot11.Address1))
+				m.alert(wifiReplay, e, dot11)
+				count(m.Hub, "wifi", "arp-replays")
 				return true
 			}
 			return false
@@ -117,3 +191,11 @@ func (m *WiFiModule) arpReplay(dot11 *layers.Dot11, data []byte, cur time.Time)
 
 	return true
 }
+
+// alert publishes an alert about the transmitter and receiver of a frame.
+func (m *WiFiModule) alert(rule *hub.Rule, e *hub.PacketEvent, dot11 *layers.Dot11) {
+	alert := hub.NewAlert(rule, e, dot11.Address1)
+	alert.Source.MAC = dot11.Address2.String()
+	alert.Destination.MAC = dot11.Address1.String()
+	m.Hub.Publish(alert)
+}
@@ -1,32 +1,233 @@
 package module
 
 import (
+	"fmt"
+	"io"
 	"log"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/Hjdskes/ET4397IN/config"
-	"github.com/google/gopacket"
+	"github.com/Hjdskes/ET4397IN/hub"
+	"github.com/Hjdskes/ET4397IN/pcapng"
+	"github.com/Hjdskes/ET4397IN/rotate"
+	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcapgo"
 )
 
+func init() {
+	Register("write", "Saves all packets into the files given by --path", func(env *Environment) Module {
+		return &WriteModule{Path: env.Path, Snaplen: env.Snaplen, LinkType: env.LinkType}
+	})
+	config.Register("write", func() interface{} {
+		return &WriteConfig{Format: "pcapng"}
+	})
+}
+
+// WriteFormats are the valid values of WriteConfig.Format.
+var WriteFormats = []string{"pcapng", "pcap"}
+
+// WriteConfig is the configuration section of the WriteModule. Zero values
+// disable the corresponding limit.
+type WriteConfig struct {
+	// The format of the files, see WriteFormats.
+	Format string `json:"format"`
+	// The size in bytes after which a new file is started.
+	MaxSize int64 `json:"max-size"`
+	// The time after which a new file is started.
+	Interval config.Duration `json:"interval"`
+	// The number of files that are kept, including those of earlier runs;
+	// older ones are removed.
+	MaxFiles int `json:"max-files"`
+	// How completed files are compressed: not at all, "gzip" or "zstd".
+	Compress string `json:"compress"`
+}
+
+func (c *WriteConfig) Validate() []config.Problem {
+	var ps []config.Problem
+	if !contains(WriteFormats, c.Format) {
+		ps = append(ps, config.Problem{Path: "format", Message: fmt.Sprintf("Invalid format %q, must be one of %s", c.Format, strings.Join(WriteFormats, ", "))})
+	}
+	if c.MaxSize < 0 {
+		ps = append(ps, config.Problem{Path: "max-size", Message: fmt.Sprintf("Must not be negative, found %d", c.MaxSize)})
+	}
+	if c.Interval.Duration < 0 {
+		ps = append(ps, config.Problem{Path: "interval", Message: "Must not be negative, found " + c.Interval.String()})
+	}
+	if c.MaxFiles < 0 {
+		ps = append(ps, config.Problem{Path: "max-files", Message: fmt.Sprintf("Must not be negative, found %d", c.MaxFiles)})
+	}
+	if !contains(rotate.Compressions, c.Compress) {
+		ps = append(ps, config.Problem{Path: "compress", Message: fmt.Sprintf("Invalid compression %q, must be one of %s", c.Compress, strings.Join(rotate.Compressions[1:], ", "))})
+	}
+	return ps
+}
+
+var _ hub.VerdictHandler = (*WriteModule)(nil)
+
+// The WriteModule saves all packets into a series of pcapng or pcap files,
+// once the modules have passed their verdict on them. Writes are buffered, so
+// the current file is only complete after Flush or Close.
 type WriteModule struct {
-	Writer *pcapgo.Writer
+	Path     string          // Template of the names of the files to create, see rotate.Expand.
+	Snaplen  int             // Maximum size of the packets, recorded in the file header.
+	LinkType layers.LinkType // Type of the first layer of the packets, recorded in the header of pcap files.
+
+	mutex     sync.Mutex // Verdicts are received from several workers at once.
+	files     *rotate.Rotator
+	newWriter func(w io.Writer) (packetWriter, error)
+	writer    packetWriter
+}
+
+// A packetWriter saves packets into a file of a particular format.
+type packetWriter interface {
+	WritePacket(e *hub.VerdictEvent) error
 }
 
-func (m WriteModule) Init(config *config.Configuration) error {
+func (m *WriteModule) Init(config *config.Configuration) error {
+	section := config.Section("write").(*WriteConfig)
+	if section.Format == "pcap" {
+		m.newWriter = m.newPcapWriter
+	} else {
+		m.newWriter = m.newPcapngWriter
+	}
+
+	files, err := rotate.New(rotate.Config{
+		Template: m.Path,
+		MaxSize:  section.MaxSize,
+		Interval: section.Interval.Duration,
+		MaxFiles: section.MaxFiles,
+		Compress: section.Compress,
+	})
+	if err != nil {
+		return err
+	}
+	m.files = files
 	return nil
 }
 
-func (m WriteModule) Topics() []string {
-	return []string{"packet"}
+func (m *WriteModule) Topics() []string {
+	return []string{hub.TopicVerdict}
+}
+
+// file returns the writer of the file a packet captured at t is saved into,
+// writing the header of a new file first.
+func (m *WriteModule) file(t time.Time) (packetWriter, error) {
+	w, created, err := m.files.File(t)
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		if m.writer, err = m.newWriter(w); err != nil {
+			return nil, err
+		}
+	}
+	return m.writer, nil
+}
+
+func (m *WriteModule) ReceiveVerdict(e *hub.VerdictEvent) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	t := e.Packet.Packet.Metadata().Timestamp
+	if t.IsZero() {
+		t = time.Now()
+	}
+	writer, err := m.file(t)
+	if err == nil {
+		err = writer.WritePacket(e)
+	}
+	if err != nil {
+		log.Println("Can't save packet:", err)
+	}
+}
+
+func (m *WriteModule) Flush() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.files.Flush()
+}
+
+func (m *WriteModule) Close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Without any packets, an empty file is still created.
+	var err error
+	if m.writer == nil {
+		_, err = m.file(time.Now())
+	}
+	if cerr := m.files.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// The pcapWriter struct saves packets into a pcap file, which has a single
+// link type and no room for the verdicts.
+type pcapWriter struct {
+	*pcapgo.Writer
 }
 
-func (m WriteModule) Receive(args []interface{}) bool {
-	packet, ok := args[0].(gopacket.Packet)
+func (m *WriteModule) newPcapWriter(w io.Writer) (packetWriter, error) {
+	writer := pcapgo.NewWriter(w)
+	return pcapWriter{writer}, writer.WriteFileHeader(uint32(m.Snaplen), m.LinkType)
+}
+
+func (w pcapWriter) WritePacket(e *hub.VerdictEvent) error {
+	packet := e.Packet.Packet
+	return w.Writer.WritePacket(packet.Metadata().CaptureInfo, packet.Data())
+}
+
+// The pcapngWriter struct saves packets into a pcapng file, describing every
+// interface before its first packet, and comments every packet with its
+// verdict and the alerts it raised.
+type pcapngWriter struct {
+	*pcapng.Writer
+	snaplen    int
+	interfaces map[pcapng.Interface]int
+}
+
+func (m *WriteModule) newPcapngWriter(w io.Writer) (packetWriter, error) {
+	writer, err := pcapng.NewWriter(w)
+	return &pcapngWriter{writer, m.Snaplen, make(map[pcapng.Interface]int)}, err
+}
+
+func (w *pcapngWriter) WritePacket(e *hub.VerdictEvent) error {
+	intf := pcapng.Interface{Name: e.Packet.Interface, LinkType: e.Packet.LinkType, Snaplen: w.snaplen}
+	index, ok := w.interfaces[intf]
 	if !ok {
-		log.Println("WriteModule received data that was not a packet")
-		return true
+		var err error
+		if index, err = w.AddInterface(intf); err != nil {
+			return err
+		}
+		w.interfaces[intf] = index
 	}
 
-	m.Writer.WritePacket(packet.Metadata().CaptureInfo, packet.Data())
-	return true
+	packet := e.Packet.Packet
+	ci := packet.Metadata().CaptureInfo
+	ci.InterfaceIndex = index
+	return w.Writer.WritePacket(ci, packet.Data(), comments(e)...)
+}
+
+// comments returns the comments on a packet: its verdict, followed by the
+// alerts it raised, such as
+//
+//	Verdict: drop
+//	Alert 12, sid 1000006 (error): Host 10.0.0.1 ...
+func comments(e *hub.VerdictEvent) []string {
+	verdict := "Verdict: accept"
+	if !e.Accept {
+		verdict = "Verdict: drop"
+	}
+	cs := []string{verdict}
+	for _, a := range e.Packet.Alerts() {
+		sid := ""
+		if a.Rule != nil {
+			sid = fmt.Sprintf(", sid %d", a.Rule.ID)
+		}
+		cs = append(cs, fmt.Sprintf("Alert %d%s (%s): %s", a.ID, sid, a.Severity, a.Message))
+	}
+	return cs
 }
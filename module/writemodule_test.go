@@ -0,0 +1,82 @@
+package module
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Hjdskes/ET4397IN/config"
+	"github.com/Hjdskes/ET4397IN/hub"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWritePcapng(t *testing.T) {
+	dir, err := ioutil.TempDir("", "write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "packets.pcapng")
+
+	c, err := config.New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert := assert.New(t)
+	m := &WriteModule{Path: path, Snaplen: 1500, LinkType: layers.LinkTypeEthernet}
+	assert.Nil(m.Init(c))
+
+	at := time.Date(2016, 11, 24, 21, 27, 9, 0, time.UTC)
+	event := func(data []byte, iface string, linkType layers.LinkType) *hub.PacketEvent {
+		packet := gopacket.NewPacket(data, linkType, gopacket.Default)
+		packet.Metadata().CaptureInfo = gopacket.CaptureInfo{Timestamp: at, CaptureLength: len(data), Length: len(data)}
+		return &hub.PacketEvent{Packet: packet, Interface: iface, LinkType: linkType}
+	}
+	wired := event([]byte{1, 2, 3, 4}, "eth0", layers.LinkTypeEthernet)
+	wireless := event([]byte{5, 6, 7, 8}, "wlan0mon", layers.LinkTypeIEEE802_11)
+
+	rule := &hub.Rule{ID: 1000004, Module: "arp", Severity: hub.Error, Template: "Spoofed"}
+	h := hub.NewHub()
+	h.Publish(hub.NewAlert(rule, wired))
+	m.ReceiveVerdict(&hub.VerdictEvent{Packet: wired, Accept: false})
+	m.ReceiveVerdict(&hub.VerdictEvent{Packet: wireless, Accept: true})
+	assert.Nil(m.Close())
+
+	data, err := ioutil.ReadFile(path)
+	if !assert.Nil(err) {
+		return
+	}
+	assert.True(bytes.Contains(data, []byte("Verdict: drop")))
+	assert.True(bytes.Contains(data, []byte(", sid 1000004 (error): Spoofed")))
+	assert.True(bytes.Contains(data, []byte("Verdict: accept")))
+
+	r, err := pcapgo.NewNgReader(bytes.NewReader(data), pcapgo.NgReaderOptions{WantMixedLinkType: true})
+	if !assert.Nil(err) {
+		return
+	}
+	for _, e := range []*hub.PacketEvent{wired, wireless} {
+		packet, ci, err := r.ReadPacketData()
+		assert.Nil(err)
+		assert.Equal(e.Packet.Data(), packet)
+		intf, err := r.Interface(ci.InterfaceIndex)
+		assert.Nil(err)
+		assert.Equal(e.Interface, intf.Name)
+		assert.Equal(e.LinkType, intf.LinkType)
+	}
+}
+
+func TestWriteConfig(t *testing.T) {
+	c := &WriteConfig{Format: "erf", MaxSize: -1, Compress: "bzip2"}
+	assert.Equal(t, []config.Problem{
+		{Path: "format", Message: `Invalid format "erf", must be one of pcapng, pcap`},
+		{Path: "max-size", Message: "Must not be negative, found -1"},
+		{Path: "compress", Message: `Invalid compression "bzip2", must be one of gzip, zstd`},
+	}, c.Validate())
+}
@@ -0,0 +1,150 @@
+// This package writes packets in the pcapng format, see
+// https://www.ietf.org/archive/id/draft-tuexen-opsawg-pcapng-05.html. Unlike
+// the classic pcap format, a pcapng file can hold the packets of several
+// interfaces with different link types, and every packet can carry comments,
+// which Wireshark shows along with it.
+package pcapng
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Block types.
+const (
+	blockSectionHeader  = 0x0a0d0d0a
+	blockInterface      = 0x00000001
+	blockEnhancedPacket = 0x00000006
+)
+
+// Option codes. The user application is an option of the section header, the
+// name and time resolution are options of interface descriptions.
+const (
+	optionEnd             = 0
+	optionComment         = 1
+	optionInterfaceName   = 2
+	optionUserApplication = 4
+	optionTimeResolution  = 9
+)
+
+// Tells readers the byte order of the section.
+const byteOrderMagic = 0x1a2b3c4d
+
+// The application recorded in the section header.
+const application = "ET4397IN"
+
+// An Interface is a source of packets, of which the packets written for it
+// have the same link type.
+type Interface struct {
+	Name     string
+	LinkType layers.LinkType
+	// The maximum size of the packets, or zero if unlimited.
+	Snaplen int
+}
+
+// A Writer writes a pcapng file of a single section. Every block is written
+// with a single call to the underlying writer; it is not buffered.
+type Writer struct {
+	w          io.Writer
+	interfaces int
+}
+
+// NewWriter returns a Writer, after writing the section header.
+func NewWriter(w io.Writer) (*Writer, error) {
+	body := make([]byte, 16)
+	binary.LittleEndian.PutUint32(body[0:4], byteOrderMagic)
+	binary.LittleEndian.PutUint16(body[4:6], 1) // Major version.
+	binary.LittleEndian.PutUint16(body[6:8], 0) // Minor version.
+	// The length of the section is not known.
+	binary.LittleEndian.PutUint64(body[8:16], ^uint64(0))
+	body = appendOption(body, optionUserApplication, []byte(application))
+	body = appendOption(body, optionEnd, nil)
+
+	ng := &Writer{w: w}
+	return ng, ng.write(blockSectionHeader, body)
+}
+
+// AddInterface writes the description of an interface, and returns the index
+// by which its packets refer to it.
+func (ng *Writer) AddInterface(i Interface) (int, error) {
+	body := make([]byte, 8)
+	binary.LittleEndian.PutUint16(body[0:2], uint16(i.LinkType))
+	binary.LittleEndian.PutUint32(body[4:8], uint32(i.Snaplen))
+	if i.Name != "" {
+		body = appendOption(body, optionInterfaceName, []byte(i.Name))
+	}
+	// Timestamps are written in nanoseconds.
+	body = appendOption(body, optionTimeResolution, []byte{9})
+	body = appendOption(body, optionEnd, nil)
+
+	if err := ng.write(blockInterface, body); err != nil {
+		return 0, err
+	}
+	ng.interfaces++
+	return ng.interfaces - 1, nil
+}
+
+// WritePacket writes a packet, with a comment for every one of comments. The
+// InterfaceIndex of ci is the index returned by AddInterface.
+func (ng *Writer) WritePacket(ci gopacket.CaptureInfo, data []byte, comments ...string) error {
+	if ci.InterfaceIndex < 0 || ci.InterfaceIndex >= ng.interfaces {
+		return fmt.Errorf("Unknown interface %d, have only %d", ci.InterfaceIndex, ng.interfaces)
+	}
+	if ci.CaptureLength != len(data) {
+		return fmt.Errorf("Capture length %d does not match data length %d", ci.CaptureLength, len(data))
+	}
+
+	ts := uint64(ci.Timestamp.UnixNano())
+	body := make([]byte, 20, 20+len(data)+3)
+	binary.LittleEndian.PutUint32(body[0:4], uint32(ci.InterfaceIndex))
+	binary.LittleEndian.PutUint32(body[4:8], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(body[8:12], uint32(ts))
+	binary.LittleEndian.PutUint32(body[12:16], uint32(ci.CaptureLength))
+	binary.LittleEndian.PutUint32(body[16:20], uint32(ci.Length))
+	body = append(body, pad(data)...)
+	for _, comment := range comments {
+		body = appendOption(body, optionComment, []byte(comment))
+	}
+	if len(comments) > 0 {
+		body = appendOption(body, optionEnd, nil)
+	}
+	return ng.write(blockEnhancedPacket, body)
+}
+
+// write writes a block of the given type, of which the length of the body is
+// a multiple of four.
+func (ng *Writer) write(kind uint32, body []byte) error {
+	length := uint32(12 + len(body))
+	block := make([]byte, 8, length)
+	binary.LittleEndian.PutUint32(block[0:4], kind)
+	binary.LittleEndian.PutUint32(block[4:8], length)
+	block = append(block, body...)
+	block = block[:length]
+	binary.LittleEndian.PutUint32(block[length-4:], length)
+	_, err := ng.w.Write(block)
+	return err
+}
+
+// appendOption appends an option to the body of a block. Values that do not
+// fit an option are truncated.
+func appendOption(body []byte, code uint16, value []byte) []byte {
+	if len(value) > 0xffff {
+		value = value[:0xffff]
+	}
+	var header [4]byte
+	binary.LittleEndian.PutUint16(header[0:2], code)
+	binary.LittleEndian.PutUint16(header[2:4], uint16(len(value)))
+	return append(append(body, header[:]...), pad(value)...)
+}
+
+// pad returns data padded with zeroes to a multiple of four bytes.
+func pad(data []byte) []byte {
+	if len(data)%4 == 0 {
+		return data
+	}
+	return append(append([]byte(nil), data...), make([]byte, 4-len(data)%4)...)
+}
@@ -0,0 +1,64 @@
+package pcapng
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriter(t *testing.T) {
+	assert := assert.New(t)
+	var buffer bytes.Buffer
+	ng, err := NewWriter(&buffer)
+	assert.Nil(err)
+	eth, err := ng.AddInterface(Interface{Name: "eth0", LinkType: layers.LinkTypeEthernet, Snaplen: 1500})
+	assert.Nil(err)
+	wlan, err := ng.AddInterface(Interface{Name: "wlan0mon", LinkType: layers.LinkTypeIEEE80211Radio})
+	assert.Nil(err)
+
+	at := time.Date(2016, 11, 24, 21, 27, 9, 123456789, time.UTC)
+	packets := []struct {
+		data     []byte
+		ci       gopacket.CaptureInfo
+		comments []string
+	}{
+		{[]byte{1, 2, 3}, gopacket.CaptureInfo{Timestamp: at, CaptureLength: 3, Length: 60, InterfaceIndex: wlan}, []string{"Verdict: drop", "Alert 7"}},
+		{[]byte{4, 5, 6, 7}, gopacket.CaptureInfo{Timestamp: at.Add(time.Second), CaptureLength: 4, Length: 4, InterfaceIndex: eth}, nil},
+	}
+	for _, p := range packets {
+		assert.Nil(ng.WritePacket(p.ci, p.data, p.comments...))
+	}
+	assert.NotNil(ng.WritePacket(gopacket.CaptureInfo{InterfaceIndex: 2}, nil))
+
+	// The comments are written as they are.
+	assert.True(bytes.Contains(buffer.Bytes(), []byte("Verdict: drop")))
+	assert.True(bytes.Contains(buffer.Bytes(), []byte("Alert 7")))
+
+	r, err := pcapgo.NewNgReader(bytes.NewReader(buffer.Bytes()), pcapgo.NgReaderOptions{WantMixedLinkType: true})
+	if !assert.Nil(err) {
+		return
+	}
+	for _, p := range packets {
+		data, ci, err := r.ReadPacketData()
+		assert.Nil(err)
+		assert.Equal(p.data, data)
+		assert.True(p.ci.Timestamp.Equal(ci.Timestamp))
+		assert.Equal(p.ci.CaptureLength, ci.CaptureLength)
+		assert.Equal(p.ci.Length, ci.Length)
+		assert.Equal(p.ci.InterfaceIndex, ci.InterfaceIndex)
+	}
+	assert.Equal(2, r.NInterfaces())
+	intf, err := r.Interface(wlan)
+	assert.Nil(err)
+	assert.Equal("wlan0mon", intf.Name)
+	assert.Equal(layers.LinkTypeIEEE80211Radio, intf.LinkType)
+	intf, err = r.Interface(eth)
+	assert.Nil(err)
+	assert.Equal(layers.LinkTypeEthernet, intf.LinkType)
+	assert.Equal(uint32(1500), intf.SnapLength)
+}
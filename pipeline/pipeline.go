@@ -0,0 +1,232 @@
+// This package implements the capture pipeline: a fixed pool of workers that
+// inspect packets, each fed by its own bounded queue. All packets of a flow
+// (the same addresses and ports, in either direction) land on the same worker,
+// so that they are inspected in the order in which they were captured.
+package pipeline
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Hjdskes/ET4397IN/stats"
+	"github.com/google/gopacket"
+)
+
+// An OverloadPolicy decides what happens to a packet when the queue of its
+// worker is full.
+type OverloadPolicy int
+
+// OverloadPolicy values.
+const (
+	// DropNewest discards the packet that could not be queued.
+	DropNewest OverloadPolicy = iota
+	// DropOldest discards the packet at the head of the queue to make
+	// room for the new one.
+	DropOldest
+	// FailOpen accepts the packet without inspecting it.
+	FailOpen
+	// FailClosed rejects the packet without inspecting it.
+	FailClosed
+	// Block waits until there is room in the queue, which slows down
+	// reading a capture file rather than losing packets.
+	Block
+)
+
+// String returns a string representation of the OverloadPolicy.
+func (p OverloadPolicy) String() string {
+	switch p {
+	case DropNewest:
+		return "drop-newest"
+	case DropOldest:
+		return "drop-oldest"
+	case FailOpen:
+		return "fail-open"
+	case FailClosed:
+		return "fail-closed"
+	case Block:
+		return "block"
+	default:
+		return "N/A"
+	}
+}
+
+// ParseOverloadPolicy returns the OverloadPolicy named by s, see
+// OverloadPolicy.String.
+func ParseOverloadPolicy(s string) (OverloadPolicy, error) {
+	for _, p := range []OverloadPolicy{DropNewest, DropOldest, FailOpen, FailClosed, Block} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return DropNewest, fmt.Errorf("Unknown overload policy: %s", s)
+}
+
+// Counters keeps count of what happened to the submitted packets.
+type Counters struct {
+	Submitted     uint64 // Packets handed to Submit.
+	Inspected     uint64 // Packets inspected by a worker.
+	Accepted      uint64 // Inspected packets that were accepted.
+	Rejected      uint64 // Inspected packets that were rejected.
+	DroppedNewest uint64 // Packets discarded because their queue was full.
+	DroppedOldest uint64 // Queued packets discarded to make room.
+	FailedOpen    uint64 // Packets accepted without inspection.
+	FailedClosed  uint64 // Packets rejected without inspection.
+}
+
+// An item is a packet waiting in a queue, together with the function that
+// receives its verdict and the time at which it was submitted.
+type item struct {
+	packet    gopacket.Packet
+	verdict   func(ok bool)
+	submitted time.Time
+}
+
+// The Pipeline struct distributes packets over its workers.
+type Pipeline struct {
+	inspect  func(packet gopacket.Packet) bool
+	policy   OverloadPolicy
+	queues   []chan item
+	wg       sync.WaitGroup
+	counters Counters // Accessed atomically.
+	latency  *stats.Histogram
+}
+
+// New creates a Pipeline and starts its workers. Each worker has a queue that
+// holds at most depth packets and calls inspect for every packet it takes
+// from it. When a queue is full, policy decides what happens.
+func New(workers, depth int, policy OverloadPolicy, inspect func(packet gopacket.Packet) bool) *Pipeline {
+	if workers < 1 {
+		workers = 1
+	}
+
+	p := &Pipeline{
+		inspect: inspect,
+		policy:  policy,
+		queues:  make([]chan item, workers),
+		latency: stats.NewHistogram(stats.LatencyBuckets),
+	}
+	for i := range p.queues {
+		p.queues[i] = make(chan item, depth)
+		p.wg.Add(1)
+		go p.work(p.queues[i])
+	}
+	return p
+}
+
+func (p *Pipeline) work(queue chan item) {
+	defer p.wg.Done()
+	for it := range queue {
+		ok := p.inspect(it.packet)
+		atomic.AddUint64(&p.counters.Inspected, 1)
+		if ok {
+			atomic.AddUint64(&p.counters.Accepted, 1)
+		} else {
+			atomic.AddUint64(&p.counters.Rejected, 1)
+		}
+		p.latency.Observe(time.Since(it.submitted))
+		it.verdict(ok)
+	}
+}
+
+// Submit queues a packet for inspection. Once it has been inspected, verdict
+// is called on the worker's goroutine with the result. Packets discarded
+// under DropNewest or DropOldest never receive a verdict; under FailOpen and
+// FailClosed the verdict is given right away on the caller's goroutine.
+//
+// Submit must not be called concurrently with or after Close.
+func (p *Pipeline) Submit(packet gopacket.Packet, verdict func(ok bool)) {
+	atomic.AddUint64(&p.counters.Submitted, 1)
+
+	it := item{packet, verdict, time.Now()}
+	queue := p.queues[flowHash(packet)%uint64(len(p.queues))]
+
+	select {
+	case queue <- it:
+		return
+	default:
+	}
+
+	switch p.policy {
+	case DropNewest:
+		atomic.AddUint64(&p.counters.DroppedNewest, 1)
+	case DropOldest:
+		// The worker may empty the queue at the same time, so keep
+		// trying until the packet fits.
+		for {
+			select {
+			case queue <- it:
+				return
+			default:
+			}
+			select {
+			case <-queue:
+				atomic.AddUint64(&p.counters.DroppedOldest, 1)
+			default:
+			}
+		}
+	case FailOpen:
+		atomic.AddUint64(&p.counters.FailedOpen, 1)
+		verdict(true)
+	case FailClosed:
+		atomic.AddUint64(&p.counters.FailedClosed, 1)
+		verdict(false)
+	case Block:
+		queue <- it
+	}
+}
+
+// Close stops accepting packets and waits until the workers have inspected
+// all queued packets.
+func (p *Pipeline) Close() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.wg.Wait()
+}
+
+// Counters returns a snapshot of the pipeline's counters.
+func (p *Pipeline) Counters() Counters {
+	return Counters{
+		Submitted:     atomic.LoadUint64(&p.counters.Submitted),
+		Inspected:     atomic.LoadUint64(&p.counters.Inspected),
+		Accepted:      atomic.LoadUint64(&p.counters.Accepted),
+		Rejected:      atomic.LoadUint64(&p.counters.Rejected),
+		DroppedNewest: atomic.LoadUint64(&p.counters.DroppedNewest),
+		DroppedOldest: atomic.LoadUint64(&p.counters.DroppedOldest),
+		FailedOpen:    atomic.LoadUint64(&p.counters.FailedOpen),
+		FailedClosed:  atomic.LoadUint64(&p.counters.FailedClosed),
+	}
+}
+
+// Latency returns the histogram of the time from the submission of a packet
+// until its verdict, for the packets that were inspected.
+func (p *Pipeline) Latency() stats.HistogramSnapshot {
+	return p.latency.Snapshot()
+}
+
+// QueueDepths returns the number of packets waiting in each worker's queue.
+func (p *Pipeline) QueueDepths() []int {
+	depths := make([]int, len(p.queues))
+	for i, queue := range p.queues {
+		depths[i] = len(queue)
+	}
+	return depths
+}
+
+// flowHash hashes the 5-tuple of a packet. The hashes of gopacket's flows are
+// symmetric, so both directions of a connection hash to the same value.
+// Packets without a network layer are hashed on their link layer addresses.
+func flowHash(packet gopacket.Packet) uint64 {
+	var hash uint64
+	if net := packet.NetworkLayer(); net != nil {
+		hash = net.NetworkFlow().FastHash()
+		if transport := packet.TransportLayer(); transport != nil {
+			hash ^= transport.TransportFlow().FastHash()
+		}
+	} else if link := packet.LinkLayer(); link != nil {
+		hash = link.LinkFlow().FastHash()
+	}
+	return hash
+}
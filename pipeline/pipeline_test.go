@@ -0,0 +1,149 @@
+package pipeline
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/stretchr/testify/assert"
+)
+
+// testPacket is a packet without any layers, so that all test packets belong
+// to the same flow.
+type testPacket struct {
+	gopacket.Packet
+	id int
+}
+
+func (p *testPacket) NetworkLayer() gopacket.NetworkLayer {
+	return nil
+}
+
+func (p *testPacket) LinkLayer() gopacket.LinkLayer {
+	return nil
+}
+
+// blockingPipeline returns a pipeline with one worker and a queue of one
+// packet, whose worker is blocked on a first packet until release is closed.
+func blockingPipeline(policy OverloadPolicy, inspected *[]int, mutex *sync.Mutex) (*Pipeline, chan struct{}) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	p := New(1, 1, policy, func(packet gopacket.Packet) bool {
+		id := packet.(*testPacket).id
+		if id == 0 {
+			close(started)
+			<-release
+		}
+		mutex.Lock()
+		*inspected = append(*inspected, id)
+		mutex.Unlock()
+		return true
+	})
+	p.Submit(&testPacket{id: 0}, func(bool) {})
+	<-started
+	return p, release
+}
+
+func TestOrder(t *testing.T) {
+	var mutex sync.Mutex
+	var inspected []int
+	p := New(4, 100, DropNewest, func(packet gopacket.Packet) bool {
+		mutex.Lock()
+		inspected = append(inspected, packet.(*testPacket).id)
+		mutex.Unlock()
+		return true
+	})
+	for i := 0; i < 100; i++ {
+		p.Submit(&testPacket{id: i}, func(bool) {})
+	}
+	p.Close()
+
+	assert := assert.New(t)
+	assert.Equal(100, len(inspected))
+	for i, id := range inspected {
+		assert.Equal(i, id)
+	}
+	assert.Equal(Counters{Submitted: 100, Inspected: 100, Accepted: 100}, p.Counters())
+}
+
+func TestDropNewest(t *testing.T) {
+	var mutex sync.Mutex
+	var inspected []int
+	p, release := blockingPipeline(DropNewest, &inspected, &mutex)
+	p.Submit(&testPacket{id: 1}, func(bool) {})
+	p.Submit(&testPacket{id: 2}, func(bool) {})
+	close(release)
+	p.Close()
+
+	assert := assert.New(t)
+	assert.Equal([]int{0, 1}, inspected)
+	assert.Equal(uint64(1), p.Counters().DroppedNewest)
+}
+
+func TestDropOldest(t *testing.T) {
+	var mutex sync.Mutex
+	var inspected []int
+	p, release := blockingPipeline(DropOldest, &inspected, &mutex)
+	p.Submit(&testPacket{id: 1}, func(bool) {})
+	p.Submit(&testPacket{id: 2}, func(bool) {})
+	close(release)
+	p.Close()
+
+	assert := assert.New(t)
+	assert.Equal([]int{0, 2}, inspected)
+	assert.Equal(uint64(1), p.Counters().DroppedOldest)
+}
+
+func TestFailOpenAndClosed(t *testing.T) {
+	for _, policy := range []OverloadPolicy{FailOpen, FailClosed} {
+		var mutex sync.Mutex
+		var inspected []int
+		var verdicts []bool
+		p, release := blockingPipeline(policy, &inspected, &mutex)
+		p.Submit(&testPacket{id: 1}, func(bool) {})
+		p.Submit(&testPacket{id: 2}, func(ok bool) {
+			verdicts = append(verdicts, ok)
+		})
+		close(release)
+		p.Close()
+
+		assert := assert.New(t)
+		assert.Equal([]int{0, 1}, inspected)
+		assert.Equal([]bool{policy == FailOpen}, verdicts)
+		counters := p.Counters()
+		assert.Equal(uint64(1), counters.FailedOpen+counters.FailedClosed)
+	}
+}
+
+func TestBlock(t *testing.T) {
+	var mutex sync.Mutex
+	var inspected []int
+	p, release := blockingPipeline(Block, &inspected, &mutex)
+	p.Submit(&testPacket{id: 1}, func(bool) {})
+
+	submitted := make(chan struct{})
+	go func() {
+		p.Submit(&testPacket{id: 2}, func(bool) {})
+		close(submitted)
+	}()
+	close(release)
+	<-submitted
+	p.Close()
+
+	assert := assert.New(t)
+	assert.Equal([]int{0, 1, 2}, inspected)
+	assert.Equal(Counters{Submitted: 3, Inspected: 3, Accepted: 3}, p.Counters())
+	assert.Equal(uint64(3), p.Latency().Count)
+}
+
+func TestParseOverloadPolicy(t *testing.T) {
+	assert := assert.New(t)
+	for _, p := range []OverloadPolicy{DropNewest, DropOldest, FailOpen, FailClosed, Block} {
+		parsed, err := ParseOverloadPolicy(p.String())
+		assert.Nil(err)
+		assert.Equal(p, parsed)
+	}
+
+	_, err := ParseOverloadPolicy("wait")
+	assert.EqualError(err, "Unknown overload policy: wait")
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"log"
+	"reflect"
+
+	"github.com/Hjdskes/ET4397IN/config"
+	"github.com/Hjdskes/ET4397IN/module"
+)
+
+// reload re-reads the configuration file, applies the overrides from the
+// environment and the command line again, validates it and hands it to the
+// modules. It returns the configuration that is in effect afterwards: if
+// anything fails, the current configuration is kept.
+func reload(path string, overrides []string, current *config.Configuration, modules []module.Module) *config.Configuration {
+	if path == "" {
+		log.Println("No configuration file to reload")
+		return current
+	}
+
+	log.Println("Reloading configuration from", path)
+	c, err := config.New(path, overrides...)
+	if err == nil {
+		err = module.Reload(modules, current, c)
+	}
+	if err != nil {
+		log.Println("Keeping the current configuration:", err)
+		return current
+	}
+
+	if !reflect.DeepEqual(c.Modules, current.Modules) {
+		log.Println("Changes to the selected modules take effect after a restart")
+	}
+	return c
+}
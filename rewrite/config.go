@@ -0,0 +1,157 @@
+package rewrite
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Hjdskes/ET4397IN/config"
+	"github.com/google/gopacket/layers"
+)
+
+func init() {
+	config.Register("rewrite", func() interface{} {
+		return &Config{Timeout: config.Duration{Duration: 5 * time.Minute}}
+	})
+}
+
+// Config is the configuration section of the packet rewriting.
+type Config struct {
+	Rules []RuleConfig `json:"rules"`
+	// Idle time after which a translated flow is forgotten.
+	Timeout config.Duration `json:"timeout"`
+}
+
+// RuleConfig is a Rule as written in the configuration file. Addresses are
+// written as strings; Source and Destination may also be networks, such as
+// "10.0.0.0/8".
+type RuleConfig struct {
+	Protocol        string `json:"protocol"` // tcp, udp, icmp, icmpv6 or empty for any.
+	Source          string `json:"source"`
+	Destination     string `json:"destination"`
+	DestinationPort uint16 `json:"destination-port"`
+
+	DNAT       string `json:"dnat"`
+	DNATPort   uint16 `json:"dnat-port"`
+	NextHopMAC string `json:"next-hop-mac"`
+	SourceMAC  string `json:"source-mac"`
+}
+
+var protocols = map[string]layers.IPProtocol{
+	"":       0,
+	"tcp":    layers.IPProtocolTCP,
+	"udp":    layers.IPProtocolUDP,
+	"icmp":   layers.IPProtocolICMPv4,
+	"icmpv6": layers.IPProtocolICMPv6,
+}
+
+func (c *Config) Validate() []config.Problem {
+	var ps []config.Problem
+	for i, rc := range c.Rules {
+		_, problems := rc.rule()
+		for _, p := range problems {
+			if p.Path == "" {
+				p.Path = config.Index("rules", i)
+			} else {
+				p.Path = config.Key(config.Index("rules", i), p.Path)
+			}
+			ps = append(ps, p)
+		}
+	}
+	if c.Timeout.Duration <= 0 {
+		ps = append(ps, config.Problem{Path: "timeout", Message: "Must be positive, found " + c.Timeout.String()})
+	}
+	return ps
+}
+
+// Compile returns the rules in the section, which must have been validated.
+func (c *Config) Compile() []Rule {
+	rules := make([]Rule, 0, len(c.Rules))
+	for _, rc := range c.Rules {
+		r, _ := rc.rule()
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// rule parses the rule, returning the problems with paths relative to it.
+func (rc *RuleConfig) rule() (Rule, []config.Problem) {
+	var r Rule
+	var ps []config.Problem
+	problem := func(path, format string, args ...interface{}) {
+		ps = append(ps, config.Problem{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	protocol, ok := protocols[strings.ToLower(rc.Protocol)]
+	if !ok {
+		problem("protocol", "Unknown protocol: %s", rc.Protocol)
+	}
+	r.Protocol = protocol
+
+	var err error
+	if rc.Source != "" {
+		if r.Source, err = parseNet(rc.Source); err != nil {
+			problem("source", "%v", err)
+		}
+	}
+	if rc.Destination != "" {
+		if r.Destination, err = parseNet(rc.Destination); err != nil {
+			problem("destination", "%v", err)
+		}
+	}
+	if rc.DNAT != "" {
+		if r.DNAT = net.ParseIP(rc.DNAT); r.DNAT == nil {
+			problem("dnat", "Invalid IP address: %s", rc.DNAT)
+		} else if r.Destination != nil && (r.DNAT.To4() == nil) != (r.Destination.IP.To4() == nil) {
+			problem("dnat", "Not of the same family as the destination: %s", rc.DNAT)
+		}
+	}
+
+	ports := r.Protocol == layers.IPProtocolTCP || r.Protocol == layers.IPProtocolUDP
+	r.DestinationPort = rc.DestinationPort
+	if r.DestinationPort != 0 && !ports {
+		problem("destination-port", "Requires the tcp or udp protocol")
+	}
+	r.DNATPort = rc.DNATPort
+	if r.DNATPort != 0 && !ports {
+		problem("dnat-port", "Requires the tcp or udp protocol")
+	}
+
+	if rc.NextHopMAC != "" {
+		if r.NextHopMAC, err = net.ParseMAC(rc.NextHopMAC); err != nil {
+			problem("next-hop-mac", "Invalid MAC address: %s", rc.NextHopMAC)
+		}
+	}
+	if rc.SourceMAC != "" {
+		if r.SourceMAC, err = net.ParseMAC(rc.SourceMAC); err != nil {
+			problem("source-mac", "Invalid MAC address: %s", rc.SourceMAC)
+		}
+	}
+
+	if rc.DNAT == "" && rc.DNATPort == 0 && rc.NextHopMAC == "" && rc.SourceMAC == "" {
+		problem("", "Rule does not rewrite anything")
+	}
+	return r, ps
+}
+
+// parseNet parses an IP address or a network in CIDR notation. An address is
+// a network of just that address.
+func parseNet(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid network: %s", s)
+		}
+		return network, nil
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("Invalid IP address: %s", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
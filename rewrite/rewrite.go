@@ -0,0 +1,335 @@
+// This package rewrites packets before they are forwarded: it translates
+// destination addresses and ports (DNAT), for instance to redirect attackers to
+// a honeypot, undoes the translation for the replies, and rewrites Ethernet
+// addresses for the next hop. Modified packets are serialized again with fresh
+// lengths and checksums.
+package rewrite
+
+import (
+	"container/list"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// A Rule rewrites the packets it matches. The zero values of the match fields
+// match any packet; those of the rewrite fields leave the packet unchanged.
+type Rule struct {
+	Protocol        layers.IPProtocol
+	Source          *net.IPNet
+	Destination     *net.IPNet
+	DestinationPort uint16 // Only for TCP and UDP.
+
+	DNAT       net.IP // New destination address, of the same family.
+	DNATPort   uint16 // New destination port, only for TCP and UDP.
+	NextHopMAC net.HardwareAddr
+	SourceMAC  net.HardwareAddr
+}
+
+// Forward returns a rule translating the destination of all packets of the
+// family of ip to ip.
+func Forward(ip net.IP) Rule {
+	return Rule{DNAT: ip}
+}
+
+// A tuple identifies the flow of a packet.
+type tuple struct {
+	protocol         layers.IPProtocol
+	src, dst         net.IP
+	srcPort, dstPort uint16
+}
+
+func (t tuple) key() natKey {
+	return natKey{t.protocol, string(t.src.To16()), string(t.dst.To16()), t.srcPort, t.dstPort}
+}
+
+func (r *Rule) matches(t tuple) bool {
+	if r.Protocol != 0 && r.Protocol != t.protocol {
+		return false
+	}
+	if r.Source != nil && !r.Source.Contains(t.src) {
+		return false
+	}
+	if r.Destination != nil && !r.Destination.Contains(t.dst) {
+		return false
+	}
+	if r.DestinationPort != 0 && r.DestinationPort != t.dstPort {
+		return false
+	}
+	// An address can only be translated into one of the same family.
+	if r.DNAT != nil && (r.DNAT.To4() == nil) != (t.dst.To4() == nil) {
+		return false
+	}
+	return true
+}
+
+type natKey struct {
+	protocol         layers.IPProtocol
+	src, dst         string
+	srcPort, dstPort uint16
+}
+
+// A natEntry holds the original destination of a translated flow, which is
+// the source of its replies, by which they are found.
+type natEntry struct {
+	reply natKey
+	addr  net.IP
+	port  uint16
+	seen  time.Time
+}
+
+// MaxFlows is the number of translated flows a Rewriter remembers. When there
+// are more, the flow that has been idle for longest is forgotten, so that a
+// flood of packets from spoofed sources cannot exhaust the memory.
+const MaxFlows = 65536
+
+// The Rewriter struct applies the first matching rule to every packet. It
+// remembers the flows it translated, so that the replies, which come from the
+// new destination, appear to come from the original one.
+type Rewriter struct {
+	now func() time.Time
+
+	mutex   sync.Mutex
+	rules   []Rule
+	timeout time.Duration // Idle time after which a translated flow is forgotten.
+	max     int           // The number of translated flows that are remembered.
+	nat     map[natKey]*list.Element
+	flows   *list.List // The natEntries in nat, the most recently seen first.
+}
+
+// New creates a Rewriter.
+func New(rules []Rule, timeout time.Duration) *Rewriter {
+	return &Rewriter{
+		now:     time.Now,
+		rules:   rules,
+		timeout: timeout,
+		max:     MaxFlows,
+		nat:     make(map[natKey]*list.Element),
+		flows:   list.New(),
+	}
+}
+
+// SetRules replaces the rules, but keeps the translated flows.
+func (r *Rewriter) SetRules(rules []Rule, timeout time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.rules = rules
+	r.timeout = timeout
+}
+
+// Rewrite returns the data of packet after applying the first matching rule
+// or, for a reply to a translated flow, after undoing the translation. If the
+// packet is not changed, such as a packet without a network layer, its data is
+// returned as is. An error is only returned for a packet that is to be
+// changed but cannot be serialized again.
+func (r *Rewriter) Rewrite(packet gopacket.Packet) ([]byte, error) {
+	var eth *layers.Ethernet
+	var ip4 *layers.IPv4
+	var ip6 *layers.IPv6
+	var tcp *layers.TCP
+	var udp *layers.UDP
+	var icmp6 *layers.ICMPv6
+	var network gopacket.NetworkLayer
+	var t tuple
+	// The first layer that cannot be serialized again, such as STP, or a
+	// layer that failed to decode.
+	var opaque gopacket.Layer
+
+	// Copy the layers that may change, so that the packet itself is left
+	// alone. Everything after the transport layer is kept as raw payload.
+	var ls []gopacket.SerializableLayer
+walk:
+	for _, layer := range packet.Layers() {
+		switch l := layer.(type) {
+		case *layers.Ethernet:
+			c := *l
+			eth = &c
+			ls = append(ls, eth)
+		case *layers.IPv4:
+			c := *l
+			ip4, network = &c, &c
+			t.protocol, t.src, t.dst = c.Protocol, c.SrcIP, c.DstIP
+			ls = append(ls, ip4)
+		case *layers.IPv6:
+			c := *l
+			ip6, network = &c, &c
+			t.protocol, t.src, t.dst = c.NextHeader, c.SrcIP, c.DstIP
+			ls = append(ls, ip6)
+		case *layers.TCP:
+			if network == nil {
+				break walk
+			}
+			c := *l
+			tcp = &c
+			t.srcPort, t.dstPort = uint16(c.SrcPort), uint16(c.DstPort)
+			ls = append(ls, tcp, gopacket.Payload(c.LayerPayload()))
+			break walk
+		case *layers.UDP:
+			if network == nil {
+				break walk
+			}
+			c := *l
+			udp = &c
+			t.srcPort, t.dstPort = uint16(c.SrcPort), uint16(c.DstPort)
+			ls = append(ls, udp, gopacket.Payload(c.LayerPayload()))
+			break walk
+		case *layers.ICMPv6:
+			if network == nil {
+				break walk
+			}
+			c := *l
+			icmp6 = &c
+			ls = append(ls, icmp6, gopacket.Payload(c.LayerPayload()))
+			break walk
+		default:
+			if network != nil {
+				ls = append(ls, gopacket.Payload(network.LayerPayload()))
+				break walk
+			}
+			s, ok := layer.(gopacket.SerializableLayer)
+			if !ok {
+				if opaque == nil {
+					opaque = layer
+				}
+				continue
+			}
+			ls = append(ls, s)
+		}
+	}
+	if network == nil {
+		return packet.Data(), nil
+	}
+
+	setAddr := func(src bool, addr net.IP) {
+		if ip4 != nil && src {
+			ip4.SrcIP = addr.To4()
+		} else if ip4 != nil {
+			ip4.DstIP = addr.To4()
+		} else if src {
+			ip6.SrcIP = addr.To16()
+		} else {
+			ip6.DstIP = addr.To16()
+		}
+	}
+	setPort := func(src bool, port uint16) {
+		if tcp != nil && src {
+			tcp.SrcPort = layers.TCPPort(port)
+		} else if tcp != nil {
+			tcp.DstPort = layers.TCPPort(port)
+		} else if udp != nil && src {
+			udp.SrcPort = layers.UDPPort(port)
+		} else if udp != nil {
+			udp.DstPort = layers.UDPPort(port)
+		}
+	}
+	ports := tcp != nil || udp != nil
+
+	r.mutex.Lock()
+	now := r.now()
+	r.sweep(now)
+	changed := false
+	if element, ok := r.nat[t.key()]; ok {
+		// A reply to a translated flow.
+		entry := element.Value.(*natEntry)
+		entry.seen = now
+		r.flows.MoveToFront(element)
+		setAddr(true, entry.addr)
+		if ports {
+			setPort(true, entry.port)
+		}
+		changed = true
+	} else if rule := r.match(t); rule != nil {
+		reply := tuple{t.protocol, t.dst, t.src, t.dstPort, t.srcPort}
+		if rule.DNAT != nil {
+			setAddr(false, rule.DNAT)
+			reply.src = rule.DNAT
+		}
+		if rule.DNATPort != 0 && ports {
+			setPort(false, rule.DNATPort)
+			reply.srcPort = rule.DNATPort
+		}
+		if rule.DNAT != nil || (rule.DNATPort != 0 && ports) {
+			r.remember(&natEntry{reply.key(), t.dst, t.dstPort, now})
+		}
+		if eth != nil && rule.NextHopMAC != nil {
+			eth.DstMAC = rule.NextHopMAC
+		}
+		if eth != nil && rule.SourceMAC != nil {
+			eth.SrcMAC = rule.SourceMAC
+		}
+		changed = true
+	}
+	r.mutex.Unlock()
+	if !changed {
+		return packet.Data(), nil
+	}
+	if opaque != nil {
+		return nil, fmt.Errorf("Cannot rewrite packets with a %v layer", opaque.LayerType())
+	}
+
+	// The checksums of the transport layers cover the addresses.
+	var err error
+	if tcp != nil {
+		err = tcp.SetNetworkLayerForChecksum(network)
+	} else if udp != nil {
+		err = udp.SetNetworkLayerForChecksum(network)
+	} else if icmp6 != nil {
+		err = icmp6.SetNetworkLayerForChecksum(network)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := gopacket.NewSerializeBuffer()
+	options := gopacket.SerializeOptions{
+		FixLengths:       true,
+		ComputeChecksums: true,
+	}
+	if err = gopacket.SerializeLayers(buffer, options, ls...); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// match returns the first rule matching t, or nil.
+func (r *Rewriter) match(t tuple) *Rule {
+	for i := range r.rules {
+		if r.rules[i].matches(t) {
+			return &r.rules[i]
+		}
+	}
+	return nil
+}
+
+// remember records a translated flow, replacing an earlier translation of it,
+// and forgets the flow that has been idle for longest if there are too many.
+func (r *Rewriter) remember(entry *natEntry) {
+	if element, ok := r.nat[entry.reply]; ok {
+		r.forget(element)
+	}
+	r.nat[entry.reply] = r.flows.PushFront(entry)
+	if r.flows.Len() > r.max {
+		r.forget(r.flows.Back())
+	}
+}
+
+func (r *Rewriter) forget(element *list.Element) {
+	delete(r.nat, element.Value.(*natEntry).reply)
+	r.flows.Remove(element)
+}
+
+// sweep forgets the flows that have been idle for longer than the timeout.
+// These are at the back of the list, so only the forgotten flows are looked
+// at.
+func (r *Rewriter) sweep(now time.Time) {
+	for element := r.flows.Back(); element != nil; element = r.flows.Back() {
+		if now.Sub(element.Value.(*natEntry).seen) <= r.timeout {
+			return
+		}
+		r.forget(element)
+	}
+}
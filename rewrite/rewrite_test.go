@@ -0,0 +1,262 @@
+package rewrite
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Hjdskes/ET4397IN/config"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatches(t *testing.T) {
+	rules := (&Config{Rules: []RuleConfig{
+		{Protocol: "tcp", Destination: "10.0.0.0/24", DestinationPort: 22, DNAT: "192.168.0.44", DNATPort: 2222},
+		{DNAT: "::1"},
+	}}).Compile()
+	ssh := tuple{layers.IPProtocolTCP, net.ParseIP("1.2.3.4"), net.ParseIP("10.0.0.5"), 40000, 22}
+	http := tuple{layers.IPProtocolTCP, net.ParseIP("1.2.3.4"), net.ParseIP("10.0.0.5"), 40000, 80}
+	ipv6 := tuple{layers.IPProtocolUDP, net.ParseIP("::2"), net.ParseIP("::3"), 53, 53}
+
+	assert := assert.New(t)
+	assert.True(rules[0].matches(ssh))
+	assert.False(rules[0].matches(http))
+	assert.False(rules[0].matches(ipv6))
+	// Addresses are only translated into ones of the same family.
+	assert.False(rules[1].matches(ssh))
+	assert.True(rules[1].matches(ipv6))
+	forward := Forward(net.ParseIP("127.0.0.1"))
+	assert.True(forward.matches(http))
+}
+
+func TestValidate(t *testing.T) {
+	c := &Config{
+		Rules: []RuleConfig{
+			{Protocol: "sctp", Source: "10.0.0.0/33", DestinationPort: 22, DNAT: "192.168.0.44"},
+			{Destination: "::1", DNAT: "10.0.0.1", NextHopMAC: "aa:bb"},
+			{Protocol: "udp"},
+		},
+	}
+
+	assert.Equal(t, []config.Problem{
+		{Path: "rules[0].protocol", Message: "Unknown protocol: sctp"},
+		{Path: "rules[0].source", Message: "Invalid network: 10.0.0.0/33"},
+		{Path: "rules[0].destination-port", Message: "Requires the tcp or udp protocol"},
+		{Path: "rules[1].dnat", Message: "Not of the same family as the destination: 10.0.0.1"},
+		{Path: "rules[1].next-hop-mac", Message: "Invalid MAC address: aa:bb"},
+		{Path: "rules[2]", Message: "Rule does not rewrite anything"},
+		{Path: "timeout", Message: "Must be positive, found 0s"},
+	}, c.Validate())
+}
+
+var (
+	clientMAC = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}
+	routerMAC = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x02}
+	ipsMAC    = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x03}
+	honeyMAC  = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x04}
+)
+
+// frame returns the packet of an Ethernet frame carrying an IPv4 or IPv6
+// packet with a TCP or UDP segment and a payload.
+func frame(t *testing.T, src, dst net.IP, transport gopacket.SerializableLayer) gopacket.Packet {
+	eth := &layers.Ethernet{SrcMAC: clientMAC, DstMAC: routerMAC}
+	var network gopacket.NetworkLayer
+	var protocol layers.IPProtocol
+	switch transport.(type) {
+	case *layers.TCP:
+		protocol = layers.IPProtocolTCP
+	case *layers.UDP:
+		protocol = layers.IPProtocolUDP
+	}
+	if src.To4() != nil {
+		eth.EthernetType = layers.EthernetTypeIPv4
+		network = &layers.IPv4{Version: 4, TTL: 64, Protocol: protocol, SrcIP: src.To4(), DstIP: dst.To4()}
+	} else {
+		eth.EthernetType = layers.EthernetTypeIPv6
+		network = &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: protocol, SrcIP: src, DstIP: dst}
+	}
+	switch l := transport.(type) {
+	case *layers.TCP:
+		l.SetNetworkLayerForChecksum(network)
+	case *layers.UDP:
+		l.SetNetworkLayerForChecksum(network)
+	}
+
+	buffer := gopacket.NewSerializeBuffer()
+	options := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	err := gopacket.SerializeLayers(buffer, options, eth, network.(gopacket.SerializableLayer), transport, gopacket.Payload("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gopacket.NewPacket(buffer.Bytes(), layers.LinkTypeEthernet, gopacket.Default)
+}
+
+// checksummed asserts that the lengths and checksums of a frame are correct,
+// by serializing its layers once more with fresh ones, and returns the packet.
+func checksummed(t *testing.T, data []byte) gopacket.Packet {
+	packet := gopacket.NewPacket(data, layers.LinkTypeEthernet, gopacket.Default)
+	if err := packet.ErrorLayer(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var ls []gopacket.SerializableLayer
+	network := packet.NetworkLayer()
+	for _, layer := range packet.Layers() {
+		switch l := layer.(type) {
+		case *layers.TCP:
+			l.SetNetworkLayerForChecksum(network)
+		case *layers.UDP:
+			l.SetNetworkLayerForChecksum(network)
+		}
+		if l, ok := layer.(gopacket.SerializableLayer); ok {
+			ls = append(ls, l)
+		} else {
+			ls = append(ls, gopacket.Payload(layer.LayerContents()))
+		}
+	}
+	buffer := gopacket.NewSerializeBuffer()
+	options := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buffer, options, ls...); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, buffer.Bytes(), data, "lengths or checksums are wrong")
+	return packet
+}
+
+func TestRewriteIPv4TCP(t *testing.T) {
+	_, servers, _ := net.ParseCIDR("10.0.0.0/24")
+	r := New([]Rule{{
+		Protocol: layers.IPProtocolTCP, Destination: servers, DestinationPort: 22,
+		DNAT: net.ParseIP("192.168.0.44"), DNATPort: 2222,
+		NextHopMAC: honeyMAC, SourceMAC: ipsMAC,
+	}}, time.Minute)
+	client, server, honeypot := net.ParseIP("1.2.3.4"), net.ParseIP("10.0.0.5"), net.ParseIP("192.168.0.44")
+
+	assert := assert.New(t)
+	original := frame(t, client, server, &layers.TCP{SrcPort: 40000, DstPort: 22, SYN: true, Seq: 1, Window: 1024})
+	data, err := r.Rewrite(original)
+	assert.Nil(err)
+	packet := checksummed(t, data)
+	eth := packet.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+	ip := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	tcp := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+	assert.Equal(honeyMAC, eth.DstMAC)
+	assert.Equal(ipsMAC, eth.SrcMAC)
+	assert.True(client.Equal(ip.SrcIP))
+	assert.True(honeypot.Equal(ip.DstIP))
+	assert.Equal(layers.TCPPort(40000), tcp.SrcPort)
+	assert.Equal(layers.TCPPort(2222), tcp.DstPort)
+	assert.Equal([]byte("hello"), tcp.Payload)
+	// The packet itself is left alone.
+	assert.True(server.Equal(original.NetworkLayer().(*layers.IPv4).DstIP))
+
+	// The reply of the honeypot appears to come from the server.
+	reply := frame(t, honeypot, client, &layers.TCP{SrcPort: 2222, DstPort: 40000, SYN: true, ACK: true, Ack: 2, Window: 1024})
+	data, err = r.Rewrite(reply)
+	assert.Nil(err)
+	packet = checksummed(t, data)
+	ip = packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	tcp = packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+	assert.True(server.Equal(ip.SrcIP))
+	assert.True(client.Equal(ip.DstIP))
+	assert.Equal(layers.TCPPort(22), tcp.SrcPort)
+	assert.Equal(layers.TCPPort(40000), tcp.DstPort)
+
+	// Packets no rule applies to are returned as they are.
+	other := frame(t, client, server, &layers.TCP{SrcPort: 40001, DstPort: 80, SYN: true, Window: 1024})
+	data, err = r.Rewrite(other)
+	assert.Nil(err)
+	assert.Equal(other.Data(), data)
+}
+
+func TestRewriteIPv6UDP(t *testing.T) {
+	r := New([]Rule{{Protocol: layers.IPProtocolUDP, DestinationPort: 7000, DNAT: net.ParseIP("fd00::7"), DNATPort: 7001}}, time.Minute)
+	client, server, honeypot := net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), net.ParseIP("fd00::7")
+
+	assert := assert.New(t)
+	data, err := r.Rewrite(frame(t, client, server, &layers.UDP{SrcPort: 1234, DstPort: 7000}))
+	assert.Nil(err)
+	packet := checksummed(t, data)
+	ip := packet.Layer(layers.LayerTypeIPv6).(*layers.IPv6)
+	udp := packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	assert.True(client.Equal(ip.SrcIP))
+	assert.True(honeypot.Equal(ip.DstIP))
+	assert.Equal(layers.UDPPort(7001), udp.DstPort)
+	assert.Equal([]byte("hello"), udp.Payload)
+	// Ethernet addresses are only rewritten by rules that say so.
+	assert.Equal(routerMAC, packet.Layer(layers.LayerTypeEthernet).(*layers.Ethernet).DstMAC)
+
+	data, err = r.Rewrite(frame(t, honeypot, client, &layers.UDP{SrcPort: 7001, DstPort: 1234}))
+	assert.Nil(err)
+	packet = checksummed(t, data)
+	ip = packet.Layer(layers.LayerTypeIPv6).(*layers.IPv6)
+	udp = packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	assert.True(server.Equal(ip.SrcIP))
+	assert.True(client.Equal(ip.DstIP))
+	assert.Equal(layers.UDPPort(7000), udp.SrcPort)
+	assert.Equal(layers.UDPPort(1234), udp.DstPort)
+}
+
+func TestRewriteSweep(t *testing.T) {
+	r := New([]Rule{Forward(net.ParseIP("127.0.0.1"))}, time.Minute)
+	now := time.Date(2016, 11, 24, 21, 27, 9, 0, time.UTC)
+	r.now = func() time.Time { return now }
+	client, server, forward := net.ParseIP("1.2.3.4"), net.ParseIP("10.0.0.5"), net.ParseIP("127.0.0.1")
+	reply := frame(t, forward, client, &layers.UDP{SrcPort: 7000, DstPort: 1234})
+	replySource := func() net.IP {
+		data, err := r.Rewrite(reply)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return checksummed(t, data).Layer(layers.LayerTypeIPv4).(*layers.IPv4).SrcIP
+	}
+
+	assert := assert.New(t)
+	_, err := r.Rewrite(frame(t, client, server, &layers.UDP{SrcPort: 1234, DstPort: 7000}))
+	assert.Nil(err)
+	assert.Len(r.nat, 1)
+
+	// A reply keeps the flow alive.
+	now = now.Add(50 * time.Second)
+	assert.True(server.Equal(replySource()))
+	now = now.Add(50 * time.Second)
+	assert.True(server.Equal(replySource()))
+
+	// After an idle minute, the flow is forgotten, and the reply is
+	// forwarded like any other packet.
+	now = now.Add(61 * time.Second)
+	data, err := r.Rewrite(reply)
+	assert.Nil(err)
+	assert.Len(r.nat, 1)
+	dst := checksummed(t, data).Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	assert.True(forward.Equal(dst.SrcIP))
+	assert.True(forward.Equal(dst.DstIP))
+}
+
+func TestRewriteMaxFlows(t *testing.T) {
+	r := New([]Rule{{Protocol: layers.IPProtocolUDP, DestinationPort: 7000, DNAT: net.ParseIP("192.168.0.44")}}, time.Minute)
+	r.max = 3
+	server, honeypot := net.ParseIP("10.0.0.5"), net.ParseIP("192.168.0.44")
+	clients := []net.IP{net.ParseIP("1.2.3.1"), net.ParseIP("1.2.3.2"), net.ParseIP("1.2.3.3"), net.ParseIP("1.2.3.4"), net.ParseIP("1.2.3.5")}
+
+	assert := assert.New(t)
+	for _, client := range clients {
+		_, err := r.Rewrite(frame(t, client, server, &layers.UDP{SrcPort: 1234, DstPort: 7000}))
+		assert.Nil(err)
+	}
+	assert.Len(r.nat, 3)
+
+	// The flows that have been idle for longest are forgotten.
+	for i, client := range clients {
+		reply := frame(t, honeypot, client, &layers.UDP{SrcPort: 7000, DstPort: 1234})
+		data, err := r.Rewrite(reply)
+		assert.Nil(err)
+		if i < 2 {
+			assert.Equal(reply.Data(), data)
+		} else {
+			assert.True(server.Equal(checksummed(t, data).Layer(layers.LayerTypeIPv4).(*layers.IPv4).SrcIP))
+		}
+	}
+}
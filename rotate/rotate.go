@@ -0,0 +1,339 @@
+// This package writes a stream of records, such as captured packets, into a
+// series of files. A new file is started when the current one has grown too
+// large or has been written to for too long; completed files can be
+// compressed, and only the most recent ones are kept.
+package rotate
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressions are the valid values of Config.Compress; the empty string
+// leaves the files uncompressed.
+var Compressions = []string{"", "gzip", "zstd"}
+
+// The extensions of the compressed files, by method.
+var extensions = map[string]string{"gzip": ".gz", "zstd": ".zst"}
+
+// Config tells when to start a new file and what to do with the completed
+// ones. Zero values disable the corresponding limit.
+type Config struct {
+	// The name of the files, see Expand.
+	Template string
+	// The size in bytes after which a new file is started.
+	MaxSize int64
+	// The time after which a new file is started, measured by the times
+	// of the records.
+	Interval time.Duration
+	// The number of files that are kept, including the current one. The
+	// files left behind by an earlier run, those in the directory of the
+	// template of which the names match it, count as well, unless the
+	// directory itself contains placeholders.
+	MaxFiles int
+	// How completed files are compressed, see Compressions.
+	Compress string
+}
+
+// A Rotator hands out the file a record is to be written to. It is not safe
+// for concurrent use.
+type Rotator struct {
+	config Config
+
+	file    *os.File
+	buffer  *bufio.Writer
+	name    string
+	size    int64
+	started time.Time
+	seq     int
+	used    map[string]bool
+
+	// Completed files are compressed and pruned in order by finish, so
+	// that writing packets does not wait for them. They are queued in
+	// pending, which is never full, and finish is woken up through wake.
+	mutex   sync.Mutex
+	pending []completed
+	closed  bool
+	wake    chan struct{}
+	done    sync.WaitGroup
+	kept    []string // Oldest first.
+}
+
+// New returns a Rotator. No file is created until the first record.
+func New(c Config) (*Rotator, error) {
+	valid := false
+	for _, compress := range Compressions {
+		valid = valid || compress == c.Compress
+	}
+	if !valid {
+		return nil, fmt.Errorf("Invalid compression %q, must be one of gzip, zstd", c.Compress)
+	}
+
+	r := &Rotator{config: c, used: make(map[string]bool), wake: make(chan struct{}, 1)}
+	if c.MaxFiles > 0 {
+		r.kept = existing(c.Template)
+	}
+	r.done.Add(1)
+	go r.finish()
+	return r, nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	*c.n += int64(n)
+	return n, err
+}
+
+// File returns the writer of the file a record captured at t is written to.
+// If the current file is full or too old, or there is none yet, a new file is
+// started and created is true, so that the caller can write its header
+// first. Records are never split over files, so a file may grow beyond the
+// maximum size by the size of one record.
+func (r *Rotator) File(t time.Time) (w io.Writer, created bool, err error) {
+	if r.file != nil && !r.full(t) {
+		return countingWriter{r.buffer, &r.size}, false, nil
+	}
+	if err := r.complete(false); err != nil {
+		return nil, false, err
+	}
+
+	r.seq++
+	file, name, err := r.create(Expand(r.config.Template, t, r.seq))
+	if err != nil {
+		return nil, false, err
+	}
+	r.file, r.buffer, r.name = file, bufio.NewWriter(file), name
+	r.size, r.started = 0, t
+	return countingWriter{r.buffer, &r.size}, true, nil
+}
+
+func (r *Rotator) full(t time.Time) bool {
+	return r.config.MaxSize > 0 && r.size >= r.config.MaxSize ||
+		r.config.Interval > 0 && t.Sub(r.started) >= r.config.Interval
+}
+
+// create creates the file called name or, if that name was used before or a
+// file of that name exists already, compressed or not, the file called name
+// with a number inserted before its extension. Existing files, such as those
+// of an earlier run, are never overwritten.
+func (r *Rotator) create(name string) (*os.File, string, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		if !r.used[name] && !compressed(name) {
+			file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+			if err == nil {
+				r.used[name] = true
+				return file, name, nil
+			}
+			if !os.IsExist(err) {
+				return nil, "", err
+			}
+		}
+		name = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+}
+
+// compressed returns whether a compressed version of a file exists.
+func compressed(name string) bool {
+	for _, ext := range extensions {
+		if _, err := os.Lstat(name + ext); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// existing returns the files of earlier runs that match template, oldest
+// first.
+func existing(template string) []string {
+	dir := filepath.Dir(template)
+	if strings.Contains(dir, "%") {
+		return nil
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Can't look for earlier files in %s: %v\n", dir, err)
+		}
+		return nil
+	}
+
+	re := pattern(filepath.Base(template))
+	var matching []os.FileInfo
+	for _, info := range infos {
+		if info.Mode().IsRegular() && re.MatchString(info.Name()) {
+			matching = append(matching, info)
+		}
+	}
+	sort.SliceStable(matching, func(i, j int) bool {
+		return matching[i].ModTime().Before(matching[j].ModTime())
+	})
+	names := make([]string, len(matching))
+	for i, info := range matching {
+		names[i] = filepath.Join(dir, info.Name())
+	}
+	return names
+}
+
+// A completed file, and whether it is the last one.
+type completed struct {
+	name string
+	last bool
+}
+
+// complete closes the current file, if any, and hands it to finish without
+// waiting for it.
+func (r *Rotator) complete(last bool) error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.buffer.Flush()
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	r.queue(completed{r.name, last})
+	r.file, r.buffer = nil, nil
+	return err
+}
+
+// queue hands a completed file to finish.
+func (r *Rotator) queue(c completed) {
+	r.mutex.Lock()
+	r.pending = append(r.pending, c)
+	if n := len(r.pending); n%16 == 0 {
+		log.Printf("Compressing files falls behind, %d files are waiting\n", n)
+	}
+	r.mutex.Unlock()
+	r.signal()
+}
+
+func (r *Rotator) signal() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// finish compresses the completed files and removes the oldest ones, until
+// the Rotator is closed and no files are pending.
+func (r *Rotator) finish() {
+	defer r.done.Done()
+	for {
+		r.mutex.Lock()
+		pending, closed := r.pending, r.closed
+		r.pending = nil
+		r.mutex.Unlock()
+		if len(pending) == 0 && closed {
+			return
+		}
+		for _, c := range pending {
+			r.finishFile(c)
+		}
+		if len(pending) == 0 {
+			<-r.wake
+		}
+	}
+}
+
+// finishFile compresses a completed file and removes the oldest files.
+func (r *Rotator) finishFile(c completed) {
+	name := c.name
+	if r.config.Compress != "" {
+		compressed, err := compress(name, r.config.Compress)
+		if err != nil {
+			log.Printf("Can't compress %s: %v\n", name, err)
+		} else {
+			name = compressed
+		}
+	}
+
+	r.kept = append(r.kept, name)
+	// Unless this was the last file, the next one counts as well.
+	max := r.config.MaxFiles
+	if !c.last {
+		max--
+	}
+	for r.config.MaxFiles > 0 && len(r.kept) > max {
+		if err := os.Remove(r.kept[0]); err != nil && !os.IsNotExist(err) {
+			log.Printf("Can't remove %s: %v\n", r.kept[0], err)
+		}
+		r.kept = r.kept[1:]
+	}
+}
+
+// compress compresses a file into a new file with the extension of the
+// method, and removes the original.
+func compress(name, method string) (string, error) {
+	in, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	ext := extensions[method]
+	out, err := os.OpenFile(name+ext, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return "", err
+	}
+
+	var w io.WriteCloser
+	if method == "gzip" {
+		w = gzip.NewWriter(out)
+	} else if w, err = zstd.NewWriter(out); err != nil {
+		out.Close()
+		os.Remove(name + ext)
+		return "", err
+	}
+
+	_, err = io.Copy(w, in)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(name + ext)
+		return "", err
+	}
+	return name + ext, os.Remove(name)
+}
+
+// Flush writes the buffered records into the current file.
+func (r *Rotator) Flush() error {
+	if r.buffer == nil {
+		return nil
+	}
+	return r.buffer.Flush()
+}
+
+// Close completes the current file and waits until all completed files have
+// been compressed and pruned.
+func (r *Rotator) Close() error {
+	err := r.complete(true)
+	r.mutex.Lock()
+	r.closed = true
+	r.mutex.Unlock()
+	r.signal()
+	r.done.Wait()
+	return err
+}
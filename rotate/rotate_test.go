@@ -0,0 +1,167 @@
+package rotate
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpand(t *testing.T) {
+	assert := assert.New(t)
+	at := time.Date(2016, time.March, 7, 9, 5, 3, 0, time.UTC)
+	assert.Equal("capture-20160307-090503.pcap", Expand("capture-%Y%m%d-%H%M%S.pcap", at, 1))
+	assert.Equal("1457341503-12.pcap", Expand("%s-%n.pcap", at, 12))
+	assert.Equal("100%-%x%", Expand("100%%-%x%", at, 1))
+}
+
+func TestPattern(t *testing.T) {
+	assert := assert.New(t)
+	re := pattern("capture-%Y%m%d-%H%M%S.pcap")
+	assert.True(re.MatchString("capture-20160307-090503.pcap"))
+	assert.True(re.MatchString("capture-20160307-090503-2.pcap.zst"))
+	assert.False(re.MatchString("capture-20160307-0905.pcap"))
+	assert.False(re.MatchString("capture-20160307-090503.pcapng"))
+
+	re = pattern("100%%.pcap")
+	assert.True(re.MatchString("100%.pcap.gz"))
+	assert.False(re.MatchString("1000.pcap"))
+}
+
+// write writes records through a Rotator, in a directory that holds the
+// existing files, which were modified in the order of their names, and returns
+// the files it left behind.
+func write(t *testing.T, c Config, existing map[string]string, records []string, times []time.Time) (map[string]string, error) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c.Template = filepath.Join(dir, c.Template)
+	var before []string
+	for name := range existing {
+		before = append(before, name)
+	}
+	sort.Strings(before)
+	modified := time.Date(2016, time.March, 1, 0, 0, 0, 0, time.UTC)
+	for i, name := range before {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(existing[name]), 0666); err != nil {
+			t.Fatal(err)
+		}
+		at := modified.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(path, at, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := New(c)
+	if err != nil {
+		return nil, err
+	}
+	for i, record := range records {
+		w, created, err := r.File(times[i])
+		if err != nil {
+			return nil, err
+		}
+		if created {
+			io.WriteString(w, "H")
+		}
+		io.WriteString(w, record)
+	}
+	if err := r.Close(); err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]string)
+	names, _ := filepath.Glob(filepath.Join(dir, "*"))
+	sort.Strings(names)
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		var in io.Reader = f
+		if filepath.Ext(name) == ".gz" && existing[filepath.Base(name)] == "" {
+			if in, err = gzip.NewReader(f); err != nil {
+				return nil, err
+			}
+		}
+		data, err := ioutil.ReadAll(in)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		files[filepath.Base(name)] = string(data)
+	}
+	return files, nil
+}
+
+func TestRotateBySize(t *testing.T) {
+	assert := assert.New(t)
+	start := time.Date(2016, time.March, 7, 9, 0, 0, 0, time.UTC)
+	times := make([]time.Time, 7)
+	for i := range times {
+		times[i] = start
+	}
+
+	// A file is full once the header and two records are in it.
+	files, err := write(t, Config{Template: "%n.pcap", MaxSize: 3, MaxFiles: 2, Compress: "gzip"}, nil,
+		[]string{"a", "b", "c", "d", "e", "f", "g"}, times)
+	assert.Nil(err)
+	assert.Equal(map[string]string{"3.pcap.gz": "Hef", "4.pcap.gz": "Hg"}, files)
+}
+
+func TestRotateByTime(t *testing.T) {
+	assert := assert.New(t)
+	start := time.Date(2016, time.March, 7, 9, 0, 0, 0, time.UTC)
+	var times []time.Time
+	for _, offset := range []time.Duration{0, 30 * time.Second, time.Minute, 90 * time.Second, 3 * time.Minute} {
+		times = append(times, start.Add(offset))
+	}
+
+	// Files are named by the time of their first record.
+	files, err := write(t, Config{Template: "%H%M.pcap", Interval: time.Minute, MaxSize: 1 << 20}, nil,
+		[]string{"a", "b", "c", "d", "e"}, times)
+	assert.Nil(err)
+	assert.Equal(map[string]string{"0900.pcap": "Hab", "0901.pcap": "Hcd", "0903.pcap": "He"}, files)
+
+	// Names that repeat are numbered.
+	files, err = write(t, Config{Template: "%H.pcap", Interval: time.Minute}, nil,
+		[]string{"a", "b", "c", "d", "e"}, times)
+	assert.Nil(err)
+	assert.Equal(map[string]string{"09.pcap": "Hab", "09-1.pcap": "Hcd", "09-2.pcap": "He"}, files)
+}
+
+func TestRotateExisting(t *testing.T) {
+	assert := assert.New(t)
+	start := time.Date(2016, time.March, 7, 9, 0, 0, 0, time.UTC)
+	times := []time.Time{start, start.Add(time.Hour), start.Add(2 * time.Hour)}
+
+	// The files of an earlier run are neither overwritten, nor, when
+	// compressed, replaced by the compressed files of this run. They do
+	// count towards the number of files that are kept, oldest first, but
+	// other files are left alone.
+	existing := map[string]string{
+		"09.pcap": "old", "10.pcap.gz": "old", "11-1.pcap": "old",
+		"notes.pcap": "other", "09.pcapng": "other",
+	}
+	files, err := write(t, Config{Template: "%H.pcap", Interval: time.Hour, MaxFiles: 4, Compress: "gzip"}, existing,
+		[]string{"a", "b", "c"}, times)
+	assert.Nil(err)
+	assert.Equal(map[string]string{
+		"notes.pcap": "other", "09.pcapng": "other", "11-1.pcap": "old",
+		"09-1.pcap.gz": "Ha", "10-1.pcap.gz": "Hb", "11.pcap.gz": "Hc",
+	}, files)
+}
+
+func TestInvalidCompression(t *testing.T) {
+	_, err := New(Config{Template: "x.pcap", Compress: "bzip2"})
+	assert.NotNil(t, err)
+}
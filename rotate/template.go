@@ -0,0 +1,102 @@
+package rotate
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expand returns the name of a file from a template, in which the following
+// placeholders are replaced as in strftime:
+//
+//	%Y  the year of t, e.g. 2016
+//	%m  the month of t, 01 to 12
+//	%d  the day of the month of t, 01 to 31
+//	%H  the hour of t, 00 to 23
+//	%M  the minute of t, 00 to 59
+//	%S  the second of t, 00 to 59
+//	%s  the Unix time of t
+//	%n  the sequence number of the file, starting at 1
+//	%%  a percent sign
+//
+// Other characters, including unknown placeholders, are copied.
+func Expand(template string, t time.Time, seq int) string {
+	var b strings.Builder
+	for i := 0; i < len(template); i++ {
+		if template[i] != '%' || i == len(template)-1 {
+			b.WriteByte(template[i])
+			continue
+		}
+		i++
+		switch template[i] {
+		case 'Y':
+			fmt.Fprintf(&b, "%04d", t.Year())
+		case 'm':
+			fmt.Fprintf(&b, "%02d", int(t.Month()))
+		case 'd':
+			fmt.Fprintf(&b, "%02d", t.Day())
+		case 'H':
+			fmt.Fprintf(&b, "%02d", t.Hour())
+		case 'M':
+			fmt.Fprintf(&b, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&b, "%02d", t.Second())
+		case 's':
+			b.WriteString(strconv.FormatInt(t.Unix(), 10))
+		case 'n':
+			b.WriteString(strconv.Itoa(seq))
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(template[i])
+		}
+	}
+	return b.String()
+}
+
+// The regular expressions matching the placeholders of Expand.
+var placeholders = map[byte]string{
+	'Y': `\d{4}`,
+	'm': `\d{2}`,
+	'd': `\d{2}`,
+	'H': `\d{2}`,
+	'M': `\d{2}`,
+	'S': `\d{2}`,
+	's': `-?\d+`,
+	'n': `\d+`,
+	'%': `%`,
+}
+
+// pattern returns a regular expression matching the names of the files a
+// Rotator creates from template: those returned by Expand, possibly with a
+// number inserted before their extension, and possibly compressed.
+func pattern(template string) *regexp.Regexp {
+	ext := filepath.Ext(template)
+	if strings.Contains(ext, "%") {
+		ext = ""
+	}
+	base := strings.TrimSuffix(template, ext)
+
+	var b strings.Builder
+	b.WriteByte('^')
+	for i := 0; i < len(base); i++ {
+		if base[i] != '%' || i == len(base)-1 {
+			b.WriteString(regexp.QuoteMeta(base[i : i+1]))
+			continue
+		}
+		i++
+		if p, ok := placeholders[base[i]]; ok {
+			b.WriteString(p)
+		} else {
+			b.WriteString(regexp.QuoteMeta(base[i-1 : i+1]))
+		}
+	}
+	b.WriteString(`(-\d+)?`)
+	b.WriteString(regexp.QuoteMeta(ext))
+	b.WriteString(`(\.gz|\.zst)?$`)
+	return regexp.MustCompile(b.String())
+}
@@ -0,0 +1,101 @@
+package sink
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/Hjdskes/ET4397IN/hub"
+)
+
+// The device described in the header of CEF records.
+const (
+	cefVendor  = "Hjdskes"
+	cefProduct = "ET4397IN"
+	cefVersion = "1.0"
+)
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`)
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+)
+
+// cefSeverity returns the severity of an alert on the scale of CEF, from 0 to
+// 10 where 10 is the most severe.
+func cefSeverity(s hub.Severity) int {
+	switch s {
+	case hub.Error:
+		return 8
+	case hub.Warning:
+		return 5
+	default:
+		return 3
+	}
+}
+
+// cef formats an alert in version 0 of ArcSight's Common Event Format. The
+// signature id is the id of the rule, or the module if there is no rule, and
+// the name is the message of the alert. IPv6 addresses are written to c6a2
+// and c6a3, since src and dst only hold IPv4 addresses.
+func cef(e *hub.AlertEvent) ([]byte, error) {
+	signature := e.Module
+	if e.Rule != nil {
+		signature = strconv.Itoa(e.Rule.ID)
+	}
+
+	var b strings.Builder
+	b.WriteString("CEF:0")
+	for _, field := range []string{cefVendor, cefProduct, cefVersion, signature, e.Message, strconv.Itoa(cefSeverity(e.Severity))} {
+		b.WriteByte('|')
+		b.WriteString(cefHeaderEscaper.Replace(field))
+	}
+	b.WriteByte('|')
+
+	act := "allowed"
+	if e.Blocked {
+		act = "blocked"
+	}
+	extension := []string{
+		"rt", strconv.FormatInt(e.Time.UnixNano()/1e6, 10),
+		"act", act,
+		"cs1Label", "module",
+		"cs1", e.Module,
+		"smac", e.Source.MAC,
+		cefAddressKey("src", "c6a2", e.Source.IP), e.Source.IP,
+		"spt", port(e.Source.Port),
+		"dmac", e.Destination.MAC,
+		cefAddressKey("dst", "c6a3", e.Destination.IP), e.Destination.IP,
+		"dpt", port(e.Destination.Port),
+		"proto", e.Protocol,
+		"deviceInboundInterface", e.Interface,
+	}
+	if e.Rule != nil {
+		extension = append(extension, "cat", e.Rule.Category)
+	}
+	separator := ""
+	for i := 0; i < len(extension); i += 2 {
+		if extension[i+1] == "" {
+			continue
+		}
+		b.WriteString(separator + extension[i] + "=" + cefExtensionEscaper.Replace(extension[i+1]))
+		separator = " "
+	}
+	return []byte(b.String()), nil
+}
+
+// cefAddressKey returns the extension key of an IP address: ipv4 for an IPv4
+// address, and ipv6 otherwise.
+func cefAddressKey(ipv4, ipv6, ip string) string {
+	if strings.Contains(ip, ":") {
+		return ipv6
+	}
+	return ipv4
+}
+
+// port returns the decimal representation of a port, or nothing if it is not
+// known.
+func port(p uint16) string {
+	if p == 0 {
+		return ""
+	}
+	return strconv.Itoa(int(p))
+}
@@ -0,0 +1,90 @@
+package sink
+
+import (
+	"encoding/json"
+
+	"github.com/Hjdskes/ET4397IN/hub"
+)
+
+// The layout of the timestamps in EVE records.
+const eveTime = "2006-01-02T15:04:05.000000-0700"
+
+// An eveAlert is an alert in the format in which Suricata writes it into
+// eve.json, see https://docs.suricata.io/en/latest/output/eve/eve-json-format.html.
+type eveAlert struct {
+	Timestamp string        `json:"timestamp"`
+	EventType string        `json:"event_type"`
+	InIface   string        `json:"in_iface,omitempty"`
+	SrcIP     string        `json:"src_ip,omitempty"`
+	SrcPort   uint16        `json:"src_port,omitempty"`
+	DestIP    string        `json:"dest_ip,omitempty"`
+	DestPort  uint16        `json:"dest_port,omitempty"`
+	Proto     string        `json:"proto,omitempty"`
+	Ether     *eveEther     `json:"ether,omitempty"`
+	Alert     eveAlertField `json:"alert"`
+	Packet    []byte        `json:"packet,omitempty"` // Encoded in base64.
+}
+
+type eveEther struct {
+	SrcMAC  string `json:"src_mac,omitempty"`
+	DestMAC string `json:"dest_mac,omitempty"`
+}
+
+type eveAlertField struct {
+	Action      string `json:"action"`
+	GID         int    `json:"gid"`
+	SignatureID int    `json:"signature_id"`
+	Rev         int    `json:"rev"`
+	Signature   string `json:"signature"`
+	Category    string `json:"category"`
+	Severity    int    `json:"severity"`
+}
+
+// eveSeverity returns the severity of an alert as in Suricata, where 1 is the
+// most severe.
+func eveSeverity(s hub.Severity) int {
+	switch s {
+	case hub.Error:
+		return 1
+	case hub.Warning:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// eveRecord encodes an alert as an EVE record, including the packet that
+// triggered it if packet is true.
+func eveRecord(e *hub.AlertEvent, packet bool) ([]byte, error) {
+	r := eveAlert{
+		Timestamp: e.Time.Format(eveTime),
+		EventType: "alert",
+		InIface:   e.Interface,
+		SrcIP:     e.Source.IP,
+		SrcPort:   e.Source.Port,
+		DestIP:    e.Destination.IP,
+		DestPort:  e.Destination.Port,
+		Proto:     e.Protocol,
+		Alert: eveAlertField{
+			Action:    "allowed",
+			GID:       1,
+			Rev:       1,
+			Signature: e.Message,
+			Severity:  eveSeverity(e.Severity),
+		},
+	}
+	if e.Source.MAC != "" || e.Destination.MAC != "" {
+		r.Ether = &eveEther{SrcMAC: e.Source.MAC, DestMAC: e.Destination.MAC}
+	}
+	if e.Blocked {
+		r.Alert.Action = "blocked"
+	}
+	if e.Rule != nil {
+		r.Alert.SignatureID = e.Rule.ID
+		r.Alert.Category = e.Rule.Category
+	}
+	if packet && e.Packet != nil {
+		r.Packet = e.Packet.Data()
+	}
+	return json.Marshal(r)
+}
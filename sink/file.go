@@ -0,0 +1,113 @@
+package sink
+
+import (
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/Hjdskes/ET4397IN/hub"
+)
+
+// The file struct appends alerts to a file, which it rotates by size.
+type file struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	format   Format
+
+	mutex sync.Mutex
+	f     *os.File
+	size  int64
+}
+
+// NewFile returns a Sink appending alerts to the file at path. If maxSize is
+// positive, the file is rotated before it would grow beyond maxSize bytes: it
+// is renamed to path.1, the previous path.1 to path.2 and so on, keeping at
+// most maxFiles of the old files.
+func NewFile(path string, maxSize int64, maxFiles int, format Format) (Sink, error) {
+	s := &file{path: path, maxSize: maxSize, maxFiles: maxFiles, format: format}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *file) open() error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f, s.size = f, info.Size()
+	return nil
+}
+
+// rotate moves the current file away, drops the oldest file and opens a new
+// one.
+func (s *file) rotate() error {
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	s.f = nil
+
+	name := func(i int) string {
+		return s.path + "." + strconv.Itoa(i)
+	}
+	os.Remove(name(s.maxFiles))
+	for i := s.maxFiles - 1; i > 0; i-- {
+		if err := os.Rename(name(i), name(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	var err error
+	if s.maxFiles > 0 {
+		err = os.Rename(s.path, name(1))
+	} else {
+		err = os.Remove(s.path)
+	}
+	if err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func (s *file) Write(e *hub.AlertEvent) error {
+	line, err := s.format(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.f == nil {
+		// A previous rotation failed halfway.
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.f.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+func (s *file) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
@@ -0,0 +1,39 @@
+package sink
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Hjdskes/ET4397IN/hub"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "alerts.log")
+
+	assert := assert.New(t)
+	// Every alert is 13 bytes long, so a file holds two of them.
+	s, err := NewFile(path, 26, 2, text)
+	assert.Nil(err)
+	for _, msg := range []string{"first", "secnd", "third", "forth", "fifth", "sixth", "seven"} {
+		assert.Nil(s.Write(&hub.AlertEvent{Severity: hub.Error, Message: msg}))
+	}
+	assert.Nil(s.Close())
+
+	read := func(name string) string {
+		data, _ := ioutil.ReadFile(name)
+		return string(data)
+	}
+	assert.Equal("ERROR: seven\n", read(path))
+	assert.Equal("ERROR: fifth\nERROR: sixth\n", read(path+".1"))
+	assert.Equal("ERROR: third\nERROR: forth\n", read(path+".2"))
+	_, err = os.Stat(path + ".3")
+	assert.True(os.IsNotExist(err))
+}
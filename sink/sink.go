@@ -0,0 +1,78 @@
+// This package implements the sinks to which the log module writes alerts:
+// the standard output, files that are rotated by size, and syslog servers.
+// Every sink formats the alerts as lines of text, as EVE records as written by
+// Suricata, or in ArcSight's Common Event Format.
+package sink
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/Hjdskes/ET4397IN/hub"
+)
+
+// A Sink writes alerts to their destination. It is used by several workers at
+// once.
+type Sink interface {
+	Write(e *hub.AlertEvent) error
+	Close() error
+}
+
+// A Format encodes an alert as a single line, without the line ending.
+type Format func(e *hub.AlertEvent) ([]byte, error)
+
+// Formats are the names of the formats, see NewFormat.
+var Formats = []string{"text", "eve", "cef"}
+
+// NewFormat returns the format with the given name. EVE records include the
+// packet that triggered the alert if packet is true.
+func NewFormat(name string, packet bool) (Format, error) {
+	switch name {
+	case "text":
+		return text, nil
+	case "eve":
+		return func(e *hub.AlertEvent) ([]byte, error) {
+			return eveRecord(e, packet)
+		}, nil
+	case "cef":
+		return cef, nil
+	default:
+		return nil, fmt.Errorf("Invalid format %q, must be one of %s", name, strings.Join(Formats, ", "))
+	}
+}
+
+// text formats an alert as its severity followed by its message.
+func text(e *hub.AlertEvent) ([]byte, error) {
+	return []byte(strings.ToUpper(e.Severity.String()) + ": " + e.Message), nil
+}
+
+// The writer struct writes alerts to an io.Writer, a line each.
+type writer struct {
+	mutex  sync.Mutex
+	w      io.Writer
+	format Format
+}
+
+// NewWriter returns a Sink writing alerts to w, such as the standard output.
+// Closing the Sink does not close w.
+func NewWriter(w io.Writer, format Format) Sink {
+	return &writer{w: w, format: format}
+}
+
+func (s *writer) Write(e *hub.AlertEvent) error {
+	line, err := s.format(e)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, err = s.w.Write(append(line, '\n'))
+	return err
+}
+
+func (s *writer) Close() error {
+	return nil
+}
@@ -0,0 +1,95 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Hjdskes/ET4397IN/hub"
+	"github.com/stretchr/testify/assert"
+)
+
+func testAlert() *hub.AlertEvent {
+	return &hub.AlertEvent{
+		ID:          1,
+		Time:        time.Date(2016, 11, 24, 21, 27, 9, 534255000, time.UTC),
+		Severity:    hub.Notice,
+		Module:      "test",
+		Rule:        &hub.Rule{ID: 1000001, Module: "test", Name: "syn", Severity: hub.Notice, Category: "Misc activity"},
+		Message:     "Host 10.0.0.1 sent a SYN",
+		Source:      hub.Endpoint{MAC: "aa:bb:cc:dd:ee:ff", IP: "10.0.0.1", Port: 1234},
+		Destination: hub.Endpoint{MAC: "11:22:33:44:55:66", IP: "10.0.0.2", Port: 80},
+		Protocol:    "TCP",
+		Interface:   "eth0",
+		Blocked:     true,
+	}
+}
+
+func TestText(t *testing.T) {
+	var out bytes.Buffer
+	format, err := NewFormat("text", false)
+
+	assert := assert.New(t)
+	assert.Nil(err)
+	s := NewWriter(&out, format)
+	assert.Nil(s.Write(testAlert()))
+	assert.Nil(s.Write(&hub.AlertEvent{Severity: hub.Error, Message: "Broken"}))
+	assert.Equal("NOTICE: Host 10.0.0.1 sent a SYN\nERROR: Broken\n", out.String())
+
+	_, err = NewFormat("xml", false)
+	assert.EqualError(err, `Invalid format "xml", must be one of text, eve, cef`)
+}
+
+func TestEVE(t *testing.T) {
+	line, err := eveRecord(testAlert(), true)
+
+	assert := assert.New(t)
+	assert.Nil(err)
+	var record map[string]interface{}
+	assert.Nil(json.Unmarshal(line, &record))
+	assert.Equal(map[string]interface{}{
+		"timestamp":  "2016-11-24T21:27:09.534255+0000",
+		"event_type": "alert",
+		"in_iface":   "eth0",
+		"src_ip":     "10.0.0.1",
+		"src_port":   float64(1234),
+		"dest_ip":    "10.0.0.2",
+		"dest_port":  float64(80),
+		"proto":      "TCP",
+		"ether":      map[string]interface{}{"src_mac": "aa:bb:cc:dd:ee:ff", "dest_mac": "11:22:33:44:55:66"},
+		"alert": map[string]interface{}{
+			"action":       "blocked",
+			"gid":          float64(1),
+			"signature_id": float64(1000001),
+			"rev":          float64(1),
+			"signature":    "Host 10.0.0.1 sent a SYN",
+			"category":     "Misc activity",
+			"severity":     float64(3),
+		},
+	}, record)
+}
+
+func TestCEF(t *testing.T) {
+	line, err := cef(testAlert())
+
+	assert := assert.New(t)
+	assert.Nil(err)
+	assert.Equal("CEF:0|Hjdskes|ET4397IN|1.0|1000001|Host 10.0.0.1 sent a SYN|3|"+
+		"rt=1480022829534 act=blocked cs1Label=module cs1=test smac=aa:bb:cc:dd:ee:ff src=10.0.0.1 spt=1234 "+
+		"dmac=11:22:33:44:55:66 dst=10.0.0.2 dpt=80 proto=TCP deviceInboundInterface=eth0 cat=Misc activity", string(line))
+
+	line, err = cef(&hub.AlertEvent{Time: time.Unix(0, 0), Severity: hub.Error, Module: "a|b", Message: `x=1\2`})
+	assert.Nil(err)
+	assert.Equal(`CEF:0|Hjdskes|ET4397IN|1.0|a\|b|x=1\\2|8|rt=0 act=allowed cs1Label=module cs1=a|b`, string(line))
+
+	// IPv6 addresses have keys of their own.
+	alert := testAlert()
+	alert.Source.IP, alert.Destination.IP = "fd00::1", "fd00::2"
+	line, err = cef(alert)
+	assert.Nil(err)
+	assert.Contains(string(line), " c6a2=fd00::1 spt=1234 ")
+	assert.Contains(string(line), " c6a3=fd00::2 dpt=80 ")
+	assert.NotContains(string(line), "src=")
+	assert.NotContains(string(line), "dst=")
+}
@@ -0,0 +1,259 @@
+package sink
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Hjdskes/ET4397IN/hub"
+)
+
+// Networks are the transports over which syslog messages can be sent: UDP, TCP
+// and a local Unix datagram socket such as /dev/log.
+var Networks = []string{"udp", "tcp", "unix"}
+
+// Facilities are the syslog facilities by name, see RFC 5424, section 6.2.1.
+var Facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// FacilityNames returns the names of the facilities, sorted.
+func FacilityNames() []string {
+	var names []string
+	for name := range Facilities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// The APP-NAME of the syslog messages.
+const appName = "et4397in"
+
+// How long to wait for a syslog server, and how long to wait before trying
+// again after it failed. Alerts are dropped in the meantime.
+const (
+	syslogTimeout = time.Second
+	syslogRetry   = 5 * time.Second
+)
+
+// The number of alerts that wait to be sent, beyond which further alerts are
+// dropped, so that workers are never held up by a server that is slow or down.
+const syslogQueue = 1024
+
+// The syslog struct sends alerts to a syslog server as RFC 5424 messages.
+type syslog struct {
+	network  string
+	address  string
+	facility int
+	format   Format
+	hostname string
+	procID   string
+	now      func() time.Time
+	dial     func() (net.Conn, error)
+
+	messages chan []byte
+	stop     chan struct{} // Closed by Close.
+	stopped  chan struct{} // Closed when send returns.
+
+	mutex   sync.Mutex
+	dropped int // The alerts dropped since one was last sent.
+
+	// Only used by send.
+	conn  net.Conn
+	retry time.Time // Before which the server is not tried again.
+}
+
+// NewSyslog returns a Sink sending alerts to the syslog server at address.
+// Over TCP, messages are framed by octet counting as in RFC 6587. The alerts
+// are sent in the background: the server is connected to when the first alert
+// is written, and again whenever it fails.
+func NewSyslog(network, address, facility string, format Format) (Sink, error) {
+	valid := false
+	for _, n := range Networks {
+		valid = valid || n == network
+	}
+	if !valid {
+		return nil, fmt.Errorf("Invalid network %q, must be one of %s", network, strings.Join(Networks, ", "))
+	}
+	code, ok := Facilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("Invalid facility %q, must be one of %s", facility, strings.Join(FacilityNames(), ", "))
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	s := &syslog{
+		network:  network,
+		address:  address,
+		facility: code,
+		format:   format,
+		hostname: hostname,
+		procID:   strconv.Itoa(os.Getpid()),
+		now:      time.Now,
+		messages: make(chan []byte, syslogQueue),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	s.dial = s.dialNetwork
+	go s.send()
+	return s, nil
+}
+
+// syslogSeverity returns the syslog severity of an alert.
+func syslogSeverity(s hub.Severity) int {
+	switch s {
+	case hub.Error:
+		return 3
+	case hub.Warning:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// message returns an alert as an RFC 5424 message, of which the MSGID is the
+// module raising the alert.
+func (s *syslog) message(e *hub.AlertEvent) ([]byte, error) {
+	msg, err := s.format(e)
+	if err != nil {
+		return nil, err
+	}
+	msgID := e.Module
+	if msgID == "" {
+		msgID = "-"
+	}
+	header := fmt.Sprintf("<%d>1 %s %s %s %s %s - ",
+		s.facility*8+syslogSeverity(e.Severity),
+		e.Time.Format("2006-01-02T15:04:05.000000Z07:00"),
+		s.hostname, appName, s.procID, msgID)
+	return append([]byte(header), msg...), nil
+}
+
+func (s *syslog) dialNetwork() (net.Conn, error) {
+	network := s.network
+	if network == "unix" {
+		network = "unixgram"
+	}
+	return net.DialTimeout(network, s.address, syslogTimeout)
+}
+
+// Write queues an alert to be sent, or drops it if too many alerts are
+// waiting already.
+func (s *syslog) Write(e *hub.AlertEvent) error {
+	msg, err := s.message(e)
+	if err != nil {
+		return err
+	}
+	if s.network == "tcp" {
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+
+	select {
+	case s.messages <- msg:
+	default:
+		s.drop(fmt.Errorf("%d alerts are waiting", syslogQueue))
+	}
+	return nil
+}
+
+// send sends the queued messages until the sink is closed, and then those
+// that are still queued, unless the server fails.
+func (s *syslog) send() {
+	defer close(s.stopped)
+	defer func() {
+		if s.conn != nil {
+			s.conn.Close()
+		}
+	}()
+
+	for {
+		select {
+		case msg := <-s.messages:
+			s.sendMessage(msg)
+		case <-s.stop:
+			sent := true
+			for {
+				select {
+				case msg := <-s.messages:
+					if sent {
+						sent = s.sendMessage(msg)
+					} else {
+						s.drop(nil)
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// sendMessage sends a message to the server, and reports whether it did.
+func (s *syslog) sendMessage(msg []byte) bool {
+	var err error
+	if s.conn == nil {
+		if s.now().Before(s.retry) {
+			s.drop(nil)
+			return false
+		}
+		if s.conn, err = s.dial(); err != nil {
+			s.fail(err)
+			return false
+		}
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	if _, err = s.conn.Write(msg); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		s.fail(err)
+		return false
+	}
+
+	s.mutex.Lock()
+	dropped := s.dropped
+	s.dropped = 0
+	s.mutex.Unlock()
+	if dropped > 0 {
+		log.Printf("Sending alerts to syslog server %s again, %d were dropped", s.address, dropped)
+	}
+	return true
+}
+
+// fail drops the alert that could not be sent, and postpones the next attempt
+// to reach the server.
+func (s *syslog) fail(err error) {
+	s.retry = s.now().Add(syslogRetry)
+	s.drop(err)
+}
+
+// drop counts a dropped alert, and logs err if it is the first one since an
+// alert was last sent.
+func (s *syslog) drop(err error) {
+	s.mutex.Lock()
+	s.dropped++
+	first := s.dropped == 1
+	s.mutex.Unlock()
+	if first && err != nil {
+		log.Printf("Can't send alerts to syslog server %s, dropping them: %v", s.address, err)
+	}
+}
+
+// Close sends the alerts that are still queued, unless the server fails, and
+// closes the connection. No alerts may be written afterwards.
+func (s *syslog) Close() error {
+	close(s.stop)
+	<-s.stopped
+	return nil
+}
@@ -0,0 +1,110 @@
+package sink
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSyslog(t *testing.T, network, address string) *syslog {
+	s, err := NewSyslog(network, address, "local3", text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sl := s.(*syslog)
+	sl.hostname, sl.procID = "sensor", "42"
+	return sl
+}
+
+const testMessage = "<157>1 2016-11-24T21:27:09.534255Z sensor et4397in 42 test - NOTICE: Host 10.0.0.1 sent a SYN"
+
+func TestSyslogUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s := newTestSyslog(t, "udp", conn.LocalAddr().String())
+	defer s.Close()
+
+	assert := assert.New(t)
+	assert.Nil(s.Write(testAlert()))
+	buffer := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(buffer)
+	assert.Nil(err)
+	assert.Equal(testMessage, string(buffer[:n]))
+}
+
+func TestSyslogTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	s := newTestSyslog(t, "tcp", l.Addr().String())
+	defer s.Close()
+
+	assert := assert.New(t)
+	assert.Nil(s.Write(testAlert()))
+	conn, err := l.Accept()
+	assert.Nil(err)
+	defer conn.Close()
+
+	// Messages are framed by their length.
+	r := bufio.NewReader(conn)
+	length, err := r.ReadString(' ')
+	assert.Nil(err)
+	assert.Equal(strconv.Itoa(len(testMessage))+" ", length)
+	msg := make([]byte, len(testMessage))
+	_, err = io.ReadFull(r, msg)
+	assert.Nil(err)
+	assert.Equal(testMessage, string(msg))
+}
+
+func TestSyslogUnavailable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	// The alerts are dropped, and the server is not tried again
+	// immediately.
+	s := newTestSyslog(t, "tcp", address)
+	assert := assert.New(t)
+	assert.Nil(s.Write(testAlert()))
+	assert.Nil(s.Write(testAlert()))
+	assert.Nil(s.Close())
+	assert.Equal(2, s.dropped)
+
+	_, err = NewSyslog("sctp", address, "local0", text)
+	assert.NotNil(err)
+	_, err = NewSyslog("udp", address, "local8", text)
+	assert.NotNil(err)
+}
+
+func TestSyslogQueue(t *testing.T) {
+	// Writing does not wait for a server that is slow to fail, but drops
+	// the alerts that do not fit in the queue.
+	s := newTestSyslog(t, "udp", "127.0.0.1:514")
+	release := make(chan struct{})
+	s.dial = func() (net.Conn, error) {
+		<-release
+		return nil, errors.New("Unreachable")
+	}
+	assert := assert.New(t)
+	for i := 0; i < syslogQueue+10; i++ {
+		assert.Nil(s.Write(testAlert()))
+	}
+	close(release)
+	assert.Nil(s.Close())
+	assert.Equal(syslogQueue+10, s.dropped)
+}
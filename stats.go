@@ -0,0 +1,121 @@
+package main
+
+import (
+	"log"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/Hjdskes/ET4397IN/capture"
+	"github.com/Hjdskes/ET4397IN/hub"
+	"github.com/Hjdskes/ET4397IN/metrics"
+	"github.com/Hjdskes/ET4397IN/pipeline"
+	"github.com/Hjdskes/ET4397IN/stats"
+)
+
+// The statistics struct gathers the statistics of every part of the IPS.
+type statistics struct {
+	src capture.Source
+	pl  *pipeline.Pipeline
+	h   *hub.Hub
+	// The names of the modules, by subscriber.
+	names map[hub.Subscriber]string
+}
+
+// report logs the statistics of the capture, the pipeline, the hub and the
+// modules.
+func (s *statistics) report() {
+	if src, ok := s.src.(capture.StatsSource); ok {
+		interfaces, err := src.Stats()
+		if err != nil {
+			log.Println(err)
+		}
+		for _, i := range interfaces {
+			log.Printf("Interface %s: received by the kernel: %d, dropped by the kernel: %d, dropped by the interface: %d\n",
+				i.Interface, i.Received, i.Dropped, i.InterfaceDropped)
+		}
+	}
+
+	c := s.pl.Counters()
+	log.Printf("Packets submitted: %d, inspected: %d, accepted: %d, rejected: %d, dropped newest: %d, dropped oldest: %d, failed open: %d, failed closed: %d\n",
+		c.Submitted, c.Inspected, c.Accepted, c.Rejected, c.DroppedNewest, c.DroppedOldest, c.FailedOpen, c.FailedClosed)
+	log.Printf("Latency until verdict: %s\n", latency(s.pl.Latency()))
+	log.Printf("Queue depths: %v, deliveries in flight: %d, goroutines: %d\n", s.pl.QueueDepths(), s.h.InFlight(), runtime.NumGoroutine())
+
+	for _, sub := range s.h.Stats() {
+		if _, ok := s.names[sub.Subscriber]; !ok {
+			continue
+		}
+		log.Printf("Module %s: received: %d, rejected: %d, missed deadline: %d, latency: %s\n",
+			s.names[sub.Subscriber], sub.Received, sub.Rejected, sub.Missed, latency(sub.Latency))
+	}
+}
+
+// collect adds the statistics to the metrics of a scrape.
+func (s *statistics) collect(set *metrics.Set) {
+	const ns = metrics.Namespace
+	if src, ok := s.src.(capture.StatsSource); ok {
+		interfaces, err := src.Stats()
+		if err != nil {
+			log.Println(err)
+		}
+		for _, i := range interfaces {
+			set.Counter(ns+"_capture_received_total", "The number of packets received by the kernel.", float64(i.Received), "interface", i.Interface)
+			set.Counter(ns+"_capture_dropped_total", "The number of packets dropped by the kernel.", float64(i.Dropped), "interface", i.Interface)
+			set.Counter(ns+"_capture_interface_dropped_total", "The number of packets dropped by the interface or its driver.", float64(i.InterfaceDropped), "interface", i.Interface)
+		}
+	}
+
+	c := s.pl.Counters()
+	set.Counter(ns+"_packets_submitted_total", "The number of packets submitted to the workers.", float64(c.Submitted))
+	set.Counter(ns+"_packets_inspected_total", "The number of packets inspected by the workers.", float64(c.Inspected))
+	help := "The number of packets by what happened to them: accepted or rejected after inspection, or dropped or passed uninspected under the overload policy."
+	for outcome, n := range map[string]uint64{
+		"accepted":       c.Accepted,
+		"rejected":       c.Rejected,
+		"dropped-newest": c.DroppedNewest,
+		"dropped-oldest": c.DroppedOldest,
+		"failed-open":    c.FailedOpen,
+		"failed-closed":  c.FailedClosed,
+	} {
+		set.Counter(ns+"_packets_total", help, float64(n), "outcome", outcome)
+	}
+	set.Histogram(ns+"_packet_latency_seconds", "The time from the submission of a packet until its verdict.", s.pl.Latency())
+	for i, depth := range s.pl.QueueDepths() {
+		set.Gauge(ns+"_queue_depth", "The number of packets waiting for a worker.", float64(depth), "worker", strconv.Itoa(i))
+	}
+	set.Gauge(ns+"_hub_in_flight", "The number of deliveries of the concurrent hub that have not returned.", float64(s.h.InFlight()))
+	set.Gauge(ns+"_goroutines", "The number of goroutines.", float64(runtime.NumGoroutine()))
+
+	for _, sub := range s.h.Stats() {
+		name, ok := s.names[sub.Subscriber]
+		if !ok {
+			continue
+		}
+		set.Counter(ns+"_module_received_total", "The number of events delivered to a module.", float64(sub.Received), "module", name)
+		set.Counter(ns+"_module_rejected_total", "The number of packets rejected by a module.", float64(sub.Rejected), "module", name)
+		set.Counter(ns+"_module_deadline_missed_total", "The number of events a module did not answer before the --deadline, and so abstained from.", float64(sub.Missed), "module", name)
+		set.Histogram(ns+"_module_latency_seconds", "The time a module took to handle an event.", sub.Latency, "module", name)
+	}
+}
+
+// run reports the statistics every interval, until done is closed.
+func (s *statistics) run(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.report()
+		case <-done:
+			return
+		}
+	}
+}
+
+// latency summarizes a histogram of latencies.
+func latency(h stats.HistogramSnapshot) string {
+	return "mean " + h.Mean().String() +
+		", p50 <= " + h.Quantile(0.5).String() +
+		", p99 <= " + h.Quantile(0.99).String()
+}
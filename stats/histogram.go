@@ -0,0 +1,100 @@
+// This package implements the building blocks of the statistics that are
+// collected while packets are inspected. They are safe for concurrent use and
+// cheap enough to update for every packet.
+package stats
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds of the buckets of a histogram of the time
+// it takes to inspect a packet.
+var LatencyBuckets = []time.Duration{
+	10 * time.Microsecond,
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+}
+
+// A Histogram counts durations in buckets. Every bucket counts the durations
+// up to and including its bound that do not fit in a bucket before it; a last
+// bucket counts the durations beyond the highest bound.
+type Histogram struct {
+	bounds []time.Duration
+	counts []uint64 // Accessed atomically, one more than bounds.
+	sum    int64    // Accessed atomically, in nanoseconds.
+}
+
+// NewHistogram returns an empty Histogram with the given bucket bounds, which
+// must be in increasing order.
+func NewHistogram(bounds []time.Duration) *Histogram {
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// Observe adds a duration to the histogram.
+func (h *Histogram) Observe(d time.Duration) {
+	i := sort.Search(len(h.bounds), func(i int) bool { return d <= h.bounds[i] })
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+// Snapshot returns the current state of the histogram.
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	s := HistogramSnapshot{
+		Bounds: h.bounds,
+		Counts: make([]uint64, len(h.counts)),
+		Sum:    time.Duration(atomic.LoadInt64(&h.sum)),
+	}
+	for i := range h.counts {
+		s.Counts[i] = atomic.LoadUint64(&h.counts[i])
+		s.Count += s.Counts[i]
+	}
+	return s
+}
+
+// A HistogramSnapshot is the state of a Histogram at some point in time.
+type HistogramSnapshot struct {
+	Bounds []time.Duration
+	Counts []uint64 // The count of every bucket, one more than Bounds.
+	Count  uint64   // The total number of durations.
+	Sum    time.Duration
+}
+
+// Mean returns the mean duration, or zero if the histogram is empty.
+func (s HistogramSnapshot) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / time.Duration(s.Count)
+}
+
+// Quantile returns the bound of the bucket that holds the q-th quantile, for
+// q between 0 and 1. If that is the last bucket, which has no bound, the
+// highest bound is returned. An empty histogram returns zero.
+func (s HistogramSnapshot) Quantile(q float64) time.Duration {
+	if s.Count == 0 || len(s.Bounds) == 0 {
+		return 0
+	}
+	rank := uint64(q*float64(s.Count) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var seen uint64
+	for i, count := range s.Counts[:len(s.Bounds)] {
+		seen += count
+		if seen >= rank {
+			return s.Bounds[i]
+		}
+	}
+	return s.Bounds[len(s.Bounds)-1]
+}
@@ -0,0 +1,44 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram(t *testing.T) {
+	assert := assert.New(t)
+	h := NewHistogram([]time.Duration{time.Millisecond, 10 * time.Millisecond})
+	h.Observe(500 * time.Microsecond)
+	h.Observe(time.Millisecond)
+	h.Observe(2 * time.Millisecond)
+	h.Observe(time.Second)
+
+	s := h.Snapshot()
+	assert.Equal([]uint64{2, 1, 1}, s.Counts)
+	assert.Equal(uint64(4), s.Count)
+	assert.Equal(1003500*time.Microsecond, s.Sum)
+	assert.Equal(s.Sum/4, s.Mean())
+}
+
+func TestQuantile(t *testing.T) {
+	assert := assert.New(t)
+	h := NewHistogram([]time.Duration{time.Millisecond, 10 * time.Millisecond})
+	assert.Equal(time.Duration(0), h.Snapshot().Quantile(0.5))
+
+	for i := 0; i < 9; i++ {
+		h.Observe(time.Microsecond)
+	}
+	h.Observe(5 * time.Millisecond)
+
+	s := h.Snapshot()
+	assert.Equal(time.Millisecond, s.Quantile(0.5))
+	assert.Equal(time.Millisecond, s.Quantile(0.9))
+	assert.Equal(10*time.Millisecond, s.Quantile(0.99))
+
+	// The last bucket has no bound, so the highest bound is reported.
+	h.Observe(time.Second)
+	h.Observe(time.Second)
+	assert.Equal(10*time.Millisecond, h.Snapshot().Quantile(1))
+}
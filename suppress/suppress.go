@@ -0,0 +1,359 @@
+// This package implements the suppression of alerts, which keeps a storm of
+// alerts from flooding the console and the sinks of the log module:
+//
+// 1. Filters drop the alerts of a rule, a source or a destination entirely.
+// 2. Identical alerts raised within a window are aggregated: the first is
+// passed on at once, and at the end of the window a summary such as "seen
+// 3,412 times in 10s" replaces the others.
+// 3. Rate limits pass on at most a number of alerts of a rule per interval,
+// and summarize the ones they held back when the interval is over.
+package suppress
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Hjdskes/ET4397IN/clock"
+	"github.com/Hjdskes/ET4397IN/hub"
+)
+
+// A Filter matches the alerts that are dropped. Empty fields match any alert.
+type Filter struct {
+	Rule        int // The id of the rule.
+	Source      Address
+	Destination Address
+}
+
+// An Address matches an endpoint by its MAC address or IP network.
+type Address struct {
+	MAC     net.HardwareAddr
+	Network *net.IPNet
+}
+
+// ParseAddress parses a MAC address, an IP address or an IP network in CIDR
+// notation. The empty string matches any endpoint.
+func ParseAddress(s string) (Address, error) {
+	if s == "" {
+		return Address{}, nil
+	}
+	if mac, err := net.ParseMAC(s); err == nil {
+		return Address{MAC: mac}, nil
+	}
+	if ip := net.ParseIP(s); ip != nil {
+		bits := 8 * net.IPv4len
+		if ip.To4() == nil {
+			bits = 8 * net.IPv6len
+		} else {
+			ip = ip.To4()
+		}
+		return Address{Network: &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}}, nil
+	}
+	if _, network, err := net.ParseCIDR(s); err == nil {
+		return Address{Network: network}, nil
+	}
+	return Address{}, fmt.Errorf("Invalid address: %s", s)
+}
+
+func (a Address) matches(e hub.Endpoint) bool {
+	switch {
+	case a.MAC != nil:
+		mac, err := net.ParseMAC(e.MAC)
+		return err == nil && mac.String() == a.MAC.String()
+	case a.Network != nil:
+		ip := net.ParseIP(e.IP)
+		return ip != nil && a.Network.Contains(ip)
+	default:
+		return true
+	}
+}
+
+func (f Filter) matches(e *hub.AlertEvent) bool {
+	return (f.Rule == 0 || e.Rule != nil && e.Rule.ID == f.Rule) &&
+		f.Source.matches(e.Source) && f.Destination.matches(e.Destination)
+}
+
+// A Limit passes on at most Alerts alerts of a rule every Interval. A Limit
+// without a rule applies to each rule that has no limit of its own.
+type Limit struct {
+	Rule     int
+	Alerts   int
+	Interval time.Duration
+}
+
+// The key of an alert, which is the same for identical alerts.
+type key struct {
+	module      string
+	rule        int
+	message     string
+	source      hub.Endpoint
+	destination hub.Endpoint
+	iface       string
+}
+
+func keyOf(e *hub.AlertEvent) key {
+	k := key{e.Module, 0, e.Message, e.Source, e.Destination, e.Interface}
+	if e.Rule != nil {
+		k.rule = e.Rule.ID
+	}
+	return k
+}
+
+// An aggregate counts the identical alerts within a window.
+type aggregate struct {
+	start time.Time
+	count int
+	last  *hub.AlertEvent
+}
+
+// The state of the rate limit of a rule.
+type rate struct {
+	limit   Limit
+	start   time.Time
+	passed  int
+	dropped int
+	last    *hub.AlertEvent
+}
+
+// How often the aggregates and rate limits are checked for having ended, at
+// most.
+const resolution = time.Second
+
+// The Suppressor struct suppresses alerts before handing them to a function.
+type Suppressor struct {
+	clock clock.Clock
+	emit  func(e *hub.AlertEvent)
+
+	mutex      sync.Mutex
+	window     time.Duration
+	filters    []Filter
+	limits     map[int]Limit
+	aggregates map[key]*aggregate
+	rates      map[int]*rate
+	ticker     clock.Ticker
+}
+
+// New returns a Suppressor passing the alerts it does not suppress to emit,
+// which may be called from the goroutine of the clock. See Configure for the
+// other arguments.
+func New(c clock.Clock, emit func(e *hub.AlertEvent), window time.Duration, limits []Limit, filters []Filter) *Suppressor {
+	s := &Suppressor{
+		clock:      c,
+		emit:       emit,
+		aggregates: make(map[key]*aggregate),
+		rates:      make(map[int]*rate),
+	}
+	s.Configure(window, limits, filters)
+	s.ticker = c.Every(s.period(), s.tick)
+	return s
+}
+
+// Configure changes the window within which identical alerts are aggregated,
+// which is disabled if not positive, the rate limits and the filters. Alerts
+// that are being aggregated or rate limited keep their current window.
+func (s *Suppressor) Configure(window time.Duration, limits []Limit, filters []Filter) {
+	s.mutex.Lock()
+	s.window = window
+	s.filters = filters
+	s.limits = make(map[int]Limit)
+	for _, l := range limits {
+		s.limits[l.Rule] = l
+	}
+	for rule, r := range s.rates {
+		// Rules that are no longer limited are summarized at the
+		// next tick.
+		if l, ok := s.limit(rule); ok {
+			r.limit = l
+		}
+	}
+	s.mutex.Unlock()
+
+	if s.ticker != nil {
+		s.ticker.Reset(s.period())
+	}
+}
+
+// period returns the interval at which the suppressor checks for aggregates
+// and rate limits that have ended.
+func (s *Suppressor) period() time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	period := resolution
+	if s.window > 0 && s.window < period {
+		period = s.window
+	}
+	for _, l := range s.limits {
+		if l.Interval < period {
+			period = l.Interval
+		}
+	}
+	return period
+}
+
+// limit returns the rate limit of a rule, if any.
+func (s *Suppressor) limit(rule int) (Limit, bool) {
+	if l, ok := s.limits[rule]; ok {
+		return l, true
+	}
+	l, ok := s.limits[0]
+	return l, ok
+}
+
+// Receive passes an alert on, unless it is suppressed.
+func (s *Suppressor) Receive(e *hub.AlertEvent) {
+	now := s.clock.Now()
+	s.mutex.Lock()
+	for _, f := range s.filters {
+		if f.matches(e) {
+			s.mutex.Unlock()
+			return
+		}
+	}
+
+	var summaries []*hub.AlertEvent
+	if s.window > 0 {
+		k := keyOf(e)
+		if a, ok := s.aggregates[k]; ok {
+			if now.Sub(a.start) < s.window {
+				a.count++
+				a.last = e
+				s.mutex.Unlock()
+				return
+			}
+			// The window ended before the ticker noticed.
+			if summary := a.summarize(s.window); summary != nil {
+				summaries = append(summaries, summary)
+			}
+		}
+		s.aggregates[k] = &aggregate{start: now, count: 1, last: e}
+	}
+
+	pass, summary := s.admit(e, now)
+	if summary != nil {
+		summaries = append(summaries, summary)
+	}
+	s.mutex.Unlock()
+
+	for _, summary := range summaries {
+		s.emit(summary)
+	}
+	if pass {
+		s.emit(e)
+	}
+}
+
+// admit returns whether the rate limit of the rule of an alert lets it pass,
+// and the summary of the previous interval of the rate limit if it ended
+// before the ticker noticed.
+func (s *Suppressor) admit(e *hub.AlertEvent, now time.Time) (bool, *hub.AlertEvent) {
+	rule := 0
+	if e.Rule != nil {
+		rule = e.Rule.ID
+	}
+	l, ok := s.limit(rule)
+	if !ok {
+		return true, nil
+	}
+
+	var summary *hub.AlertEvent
+	r, ok := s.rates[rule]
+	if ok && now.Sub(r.start) >= r.limit.Interval {
+		summary = r.summarize()
+		ok = false
+	}
+	if !ok {
+		r = &rate{limit: l, start: now}
+		s.rates[rule] = r
+	}
+	if r.passed < r.limit.Alerts {
+		r.passed++
+		return true, summary
+	}
+	r.dropped++
+	r.last = e
+	return false, summary
+}
+
+// summarize returns the summary of the alerts held back by a rate limit, or
+// nil if there are none.
+func (r *rate) summarize() *hub.AlertEvent {
+	if r.dropped == 0 {
+		return nil
+	}
+	summary := *r.last
+	summary.Message = fmt.Sprintf("%s (%s more alerts of this rule suppressed in %s)",
+		summary.Message, thousands(r.dropped), r.limit.Interval)
+	return &summary
+}
+
+// summarize returns the summary of an aggregate, or nil if it only counted
+// the alert that was passed on.
+func (a *aggregate) summarize(window time.Duration) *hub.AlertEvent {
+	if a.count < 2 {
+		return nil
+	}
+	summary := *a.last
+	summary.Message = fmt.Sprintf("%s (seen %s times in %s)", summary.Message, thousands(a.count), window)
+	return &summary
+}
+
+// collect removes the aggregates and rate limits that have ended, or all of
+// them, and returns their summaries.
+func (s *Suppressor) collect(now time.Time, all bool) []*hub.AlertEvent {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var summaries []*hub.AlertEvent
+	for k, a := range s.aggregates {
+		if !all && s.window > 0 && now.Sub(a.start) < s.window {
+			continue
+		}
+		if summary := a.summarize(s.window); summary != nil {
+			summaries = append(summaries, summary)
+		}
+		delete(s.aggregates, k)
+	}
+	for rule, r := range s.rates {
+		if _, limited := s.limit(rule); !all && limited && now.Sub(r.start) < r.limit.Interval {
+			continue
+		}
+		if summary := r.summarize(); summary != nil {
+			summaries = append(summaries, summary)
+		}
+		delete(s.rates, rule)
+	}
+	return summaries
+}
+
+// tick passes on the summaries of the aggregates and rate limits that have
+// ended.
+func (s *Suppressor) tick() {
+	for _, summary := range s.collect(s.clock.Now(), false) {
+		s.emit(summary)
+	}
+}
+
+// Flush passes on the summaries of all aggregates and rate limits, whether
+// they have ended or not.
+func (s *Suppressor) Flush() {
+	for _, summary := range s.collect(s.clock.Now(), true) {
+		s.emit(summary)
+	}
+}
+
+// Stop stops the ticker of the suppressor. Call Flush afterwards to pass on
+// the remaining summaries.
+func (s *Suppressor) Stop() {
+	s.ticker.Stop()
+}
+
+// thousands formats n with commas separating the thousands.
+func thousands(n int) string {
+	s := strconv.Itoa(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
@@ -0,0 +1,102 @@
+package suppress
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Hjdskes/ET4397IN/clock"
+	"github.com/Hjdskes/ET4397IN/hub"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	rule      = &hub.Rule{ID: 1, Module: "test", Name: "one"}
+	otherRule = &hub.Rule{ID: 2, Module: "test", Name: "two"}
+)
+
+func alert(r *hub.Rule, source, message string) *hub.AlertEvent {
+	return &hub.AlertEvent{Module: r.Module, Rule: r, Source: hub.Endpoint{IP: source}, Message: message}
+}
+
+// newTestSuppressor returns a Suppressor running on a packet clock, and the
+// messages of the alerts it passed on.
+func newTestSuppressor(window time.Duration, limits []Limit, filters []Filter) (*Suppressor, *clock.Packet, *[]string) {
+	c := clock.NewPacket()
+	c.Advance(time.Unix(0, 0))
+	var messages []string
+	s := New(c, func(e *hub.AlertEvent) {
+		messages = append(messages, e.Message)
+	}, window, limits, filters)
+	return s, c, &messages
+}
+
+func TestAggregate(t *testing.T) {
+	s, c, messages := newTestSuppressor(10*time.Second, nil, nil)
+	defer s.Stop()
+
+	for i := 0; i < 3; i++ {
+		s.Receive(alert(rule, "10.0.0.1", "Storm"))
+	}
+	s.Receive(alert(rule, "10.0.0.2", "Other"))
+
+	assert := assert.New(t)
+	assert.Equal([]string{"Storm", "Other"}, *messages)
+	c.Advance(time.Unix(9, 0))
+	assert.Equal(2, len(*messages))
+	c.Advance(time.Unix(10, 0))
+	assert.Equal([]string{"Storm", "Other", "Storm (seen 3 times in 10s)"}, *messages)
+
+	// A new window starts with the next alert.
+	s.Receive(alert(rule, "10.0.0.1", "Storm"))
+	assert.Equal(4, len(*messages))
+}
+
+func TestRateLimit(t *testing.T) {
+	s, c, messages := newTestSuppressor(0, []Limit{{Rule: 1, Alerts: 2, Interval: time.Minute}}, nil)
+	defer s.Stop()
+
+	for _, msg := range []string{"a", "b", "c", "d", "e"} {
+		s.Receive(alert(rule, "10.0.0.1", msg))
+	}
+	s.Receive(alert(otherRule, "10.0.0.1", "other"))
+
+	assert := assert.New(t)
+	assert.Equal([]string{"a", "b", "other"}, *messages)
+	c.Advance(time.Unix(59, 0))
+	assert.Equal(3, len(*messages))
+	c.Advance(time.Unix(60, 0))
+	assert.Equal([]string{"a", "b", "other", "e (3 more alerts of this rule suppressed in 1m0s)"}, *messages)
+}
+
+func TestFilter(t *testing.T) {
+	network, err := ParseAddress("10.0.0.0/24")
+	assert := assert.New(t)
+	assert.Nil(err)
+	s, _, messages := newTestSuppressor(0, nil, []Filter{{Rule: 2}, {Rule: 1, Source: network}})
+	defer s.Stop()
+
+	s.Receive(alert(rule, "10.0.0.1", "filtered"))
+	s.Receive(alert(rule, "10.0.1.1", "passed"))
+	s.Receive(alert(otherRule, "10.0.1.1", "filtered"))
+	assert.Equal([]string{"passed"}, *messages)
+
+	_, err = ParseAddress("10.0.0.0/33")
+	assert.EqualError(err, "Invalid address: 10.0.0.0/33")
+}
+
+func TestFlush(t *testing.T) {
+	s, _, messages := newTestSuppressor(time.Minute, nil, nil)
+	s.Stop()
+
+	s.Receive(alert(rule, "10.0.0.1", "Storm"))
+	s.Receive(alert(rule, "10.0.0.1", "Storm"))
+	s.Flush()
+	assert.Equal(t, []string{"Storm", "Storm (seen 2 times in 1m0s)"}, *messages)
+}
+
+func TestThousands(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("999", thousands(999))
+	assert.Equal("3,412", thousands(3412))
+	assert.Equal("1,234,567", thousands(1234567))
+}